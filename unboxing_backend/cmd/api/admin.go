@@ -2,6 +2,7 @@ package main
 
 import (
 	"company/internal/data"
+	"errors"
 	"html/template"
 	"net/http"
 )
@@ -48,50 +49,76 @@ func (app *application) registerAdminHandler(w http.ResponseWriter, r *http.Requ
 	// Retrieve form values
 	name := r.FormValue("name")
 	email := r.FormValue("email")
-	password := r.FormValue("password")
 	secretKey := r.FormValue("secret-key")
 	// Validate form inputs
-	if name == "" || email == "" || password == "" {
+	if name == "" || email == "" {
 		http.Error(w, "All fields are required", http.StatusBadRequest)
 		return
 	}
 
-	if !validateSuperSecretKey(secretKey) {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	// The endpoint is only open until the first administrator has been created,
+	// further administrators are created by an administrator like everybody else
+	exists, err := app.models.Users.AdministratorExists()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "Setup has already been completed", http.StatusForbidden)
 		return
 	}
 
-	// Create a new admin user
+	// Create a new admin user, the admin chooses their password when activating
+	// the account from the invite email
 	newUser := data.User{
-		Name:  name,
-		Email: email,
-		Role:  "Administrator",
+		Name:      name,
+		Email:     email,
+		Role:      "Administrator",
+		Activated: false,
+	}
+	if err := newUser.Password.SetRandom(); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// Insert the new user into the database, spending the setup key
+	err = app.models.Users.InsertFirstAdministrator(&newUser, secretKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidSetupKey):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case errors.Is(err, data.ErrSetupCompleted):
+			http.Error(w, "Setup has already been completed", http.StatusForbidden)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			http.Error(w, "A user with this email address already exists", http.StatusBadRequest)
+		default:
+			app.errorLogger.Println("Inserting admin into database", err)
+			http.Error(w, "Database Error in admin", http.StatusInternalServerError)
+		}
+		return
 	}
-	newUser.Password.Set(password)
-	// Insert the new user into the database
-	if err := app.models.Users.Insert(&newUser); err != nil {
-		http.Error(w, "Database Error in admin", http.StatusInternalServerError)
+	if err := app.inviteUser(&newUser); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
 	// Respond with a success message
-	w.WriteHeader(http.StatusCreated)
 	w.Header().Set("Content-Type", "text/plain")
-	w.Write([]byte("Admin user created successfully!"))
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("Admin user created successfully! Check your email to activate the account."))
 }
 
-// Helper function to validate the super secret key
-func validateSuperSecretKey(authHeader string) bool {
-	//if authHeader == "" {
-	//	return false
-	//}
-	//// Extract the key part from the header (assuming "Bearer <key>")
-	//parts := strings.Split(authHeader, " ")
-	//if len(parts) != 2 || parts[0] != "Bearer" {
-	//	return false
-	//}
-	//providedKey := parts[1]
-	//superSecretKey := os.Getenv("SECRET_KEY")
-	// Compare the provided key with the expected hash
-	return true
+// prepareSetupKey runs at startup. Once an administrator exists any leftover setup
+// key is removed. While none exists it only reminds whoever runs the server to
+// generate a setup key with `api setup-key`; keys are never generated here, since
+// every instance starting would replace the key another one handed out.
+func (app *application) prepareSetupKey() error {
+	exists, err := app.models.Users.AdministratorExists()
+	if err != nil {
+		return err
+	}
+	if exists {
+		return app.models.SetupKeys.DeleteAll()
+	}
+	app.infoLogger.Printf("no administrator exists yet, generate a setup key with `api setup-key` and register one at /admin/register")
+	return nil
 }
@@ -34,14 +34,19 @@ func (d Date) MarshalJSON() ([]byte, error) {
 }
 
 func (app *application) showBillingHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
+	id := r.PathValue("id")
 	numID, err := strconv.Atoi(id)
 	if err != nil {
 		app.errorLogger.Println("Can't get ID (int)", err)
 		http.Error(w, "Can't get ID", http.StatusBadRequest)
 		return
 	}
-	billing, err := app.models.Billing.Get(int64(numID))
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	billing, err := app.models.Billing.Get(int64(numID), scope)
 	if err != nil {
 		app.errorLogger.Println("Unable to get billing of this ID", err)
 		http.Error(w, "Billing not found", http.StatusNotFound)
@@ -57,11 +62,16 @@ func (app *application) showBillingHandler(w http.ResponseWriter, r *http.Reques
 	w.Write(data)
 }
 
+// createBillingHandler bills the amount to the customer, on the date given or today.
+// With a tax category the amount is before tax, and the tax of the category is added
+// to it. The entry is a draft, which can be changed or deleted until it is issued
+// through POST /v1/billing/{id}/issue.
 func (app *application) createBillingHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		CustomerID int64   `json:"customer_id"`
-		Amount     float64 `json:"amount"`
-		Date       Date    `json:"date"`
+		CustomerID  int64      `json:"customer_id"`
+		Amount      data.Money `json:"amount"`
+		Date        *Date      `json:"date"`
+		TaxCategory string     `json:"tax_category"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&input)
@@ -70,12 +80,39 @@ func (app *application) createBillingHandler(w http.ResponseWriter, r *http.Requ
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
+	v := validator.New()
+	data.ValidateMoney(v, "amount", input.Amount)
+	if input.TaxCategory != "" {
+		data.ValidateTaxCategory(v, "tax_category", input.TaxCategory)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	// Bills can only be written for customers the user can see
+	if ok := app.checkCustomerInScope(w, r, input.CustomerID); !ok {
+		return
+	}
 	newBilling := data.Billing{
-		CustomerID: input.CustomerID,
-		Amount:     input.Amount,
-		Date:       input.Date.Time,
+		CustomerID:  input.CustomerID,
+		Subtotal:    input.Amount,
+		Date:        time.Now().UTC().Truncate(24 * time.Hour),
+		TaxCategory: input.TaxCategory,
+	}
+	if input.Date != nil {
+		newBilling.Date = input.Date.Time
 	}
 	if err := app.models.Billing.Insert(&newBilling); err != nil {
+		var validationError *data.ValidationError
+		if errors.As(err, &validationError) {
+			app.failedValidationResponse(w, r, validationError.Errors)
+			return
+		}
+		if errors.Is(err, data.ErrUnknownTaxCategory) {
+			app.errorLogger.Println("Inserting billing with an unknown tax category", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
 		app.errorLogger.Println("Inserting billing into database", err)
 		http.Error(w, "Database Insertion Error", http.StatusInternalServerError)
 		return
@@ -91,15 +128,22 @@ func (app *application) createBillingHandler(w http.ResponseWriter, r *http.Requ
 	w.Write([]byte(`"message":"New billing created"`))
 }
 
+// updateBillingHandler changes a billing entry which is still a draft. Issued entries
+// can't be changed anymore, they are voided or credited instead.
 func (app *application) updateBillingHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
+	id := r.PathValue("id")
 	numID, err := strconv.Atoi(id)
 	if err != nil {
 		app.errorLogger.Println("Can't get ID (int)", err)
 		http.Error(w, "Can't get ID", http.StatusBadRequest)
 		return
 	}
-	billing, err := app.models.Billing.Get(int64(numID))
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	billing, err := app.models.Billing.Get(int64(numID), scope)
 	if err != nil {
 		if err == data.ErrRecordNotFound {
 			app.errorLogger.Println("Getting billing", err)
@@ -112,9 +156,9 @@ func (app *application) updateBillingHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	var input struct {
-		CustomerID *int64   `json:"customer_id"`
-		Amount     *float64 `json:"amount"`
-		Date       *Date    `json:"date"`
+		CustomerID *int64      `json:"customer_id"`
+		Amount     *data.Money `json:"amount"`
+		Date       *Date       `json:"date"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	err = decoder.Decode(&input)
@@ -125,10 +169,19 @@ func (app *application) updateBillingHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	if input.CustomerID != nil {
+		if ok := app.checkCustomerInScope(w, r, *input.CustomerID); !ok {
+			return
+		}
 		billing.CustomerID = *input.CustomerID
 	}
 	if input.Amount != nil {
-		billing.Amount = *input.Amount
+		v := validator.New()
+		if data.ValidateMoney(v, "amount", *input.Amount); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		// The amount sent is before tax, as when the entry was created
+		billing.Subtotal = *input.Amount
 	}
 	if input.Date != nil {
 		billing.Date = input.Date.Time
@@ -140,6 +193,18 @@ func (app *application) updateBillingHandler(w http.ResponseWriter, r *http.Requ
 			app.errorLogger.Println("Edit conflict", err)
 			http.Error(w, "Unable to update the record due to edit conflict, please try again", http.StatusConflict)
 			return
+		case errors.Is(err, data.ErrInvoiceNotEditable):
+			app.errorLogger.Println("Updating an issued billing", err)
+			http.Error(w, "Issued billing entries can't be changed, void their invoice instead", http.StatusConflict)
+			return
+		case errors.Is(err, data.ErrUnknownTaxCategory):
+			app.errorLogger.Println("Updating billing with an unknown tax category", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, data.ErrInvoiceHasLines):
+			app.errorLogger.Println("Billing amount of an invoice with several lines", err)
+			http.Error(w, "The amount of an invoice with several lines can only be changed through its lines", http.StatusUnprocessableEntity)
+			return
 		default:
 			app.errorLogger.Println("Updating billing ID=", billing.ID, err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -151,19 +216,29 @@ func (app *application) updateBillingHandler(w http.ResponseWriter, r *http.Requ
 	fmt.Fprintf(w, "%+v", billing)
 }
 
+// deleteBillingHandler deletes a billing entry which is still a draft.
 func (app *application) deleteBillingHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
+	id := r.PathValue("id")
 	numID, err := strconv.Atoi(id)
 	if err != nil {
 		app.errorLogger.Println("Can't get ID (int)", err)
 		http.Error(w, "Can't get ID", http.StatusBadRequest)
 		return
 	}
-	err = app.models.Billing.Delete(int64(numID))
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Billing.Delete(int64(numID), scope)
 	if err == data.ErrRecordNotFound {
 		app.errorLogger.Println("Billing ID not found", err)
 		http.Error(w, "Data not found", http.StatusNotFound)
 		return
+	} else if err == data.ErrInvoiceNotEditable {
+		app.errorLogger.Println("Deleting an issued billing", err)
+		http.Error(w, "Issued billing entries can't be deleted, void their invoice or issue a credit note instead", http.StatusConflict)
+		return
 	} else if err != nil {
 		app.errorLogger.Println("Failed delete operation", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -180,33 +255,90 @@ func (app *application) listBillingsHandler(w http.ResponseWriter, r *http.Reque
 		Filters    data.Filters
 	}
 	queryString := r.URL.Query()
+	v := validator.New()
 
-	var customerID int64
-	customerIDFromQuery := queryString.Get("customer_id")
-	if customerIDFromQuery != "" {
-		id, err := strconv.ParseInt(customerIDFromQuery, 10, 64)
-		if err == nil {
-			customerID = id
-		}
+	input.CustomerID = int64(app.readInt(queryString, "customer_id", 0, v))
+
+	// Keyset mode, used when the client passes "after" or "limit"
+	if cursorFilters, ok := app.readCursorFilters(queryString, v); ok {
+		app.listBillingsAfter(w, r, input.CustomerID, cursorFilters, v)
+		return
 	}
-	input.CustomerID = customerID
-	v := &validator.Validator{}
+
 	input.Filters.Page = app.readInt(queryString, "page", 1, v)
 	input.Filters.PageSize = app.readInt(queryString, "page_size", 20, v)
 	input.Filters.Sort = app.readString(queryString, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "customer_id", "amount", "date",
+		"-id", "-customer_id", "-amount", "-date"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	billings, err := app.models.Billing.GetAll()
+	scope, err := app.customerScope(r)
 	if err != nil {
-		app.errorLogger.Println("Getting billings", err)
-		http.Error(w, "Error when getting billings", http.StatusInternalServerError)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-	js, err := json.MarshalIndent(billings, "", "\t")
+	billings, metadata, err := app.models.Billing.GetAll(input.CustomerID, scope, input.Filters)
 	if err != nil {
-		app.errorLogger.Println("Marshalling billing, converting to JSON", err)
-		http.Error(w, "Error when getting billings", http.StatusInternalServerError)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.Write(js)
+	err = app.writeJSON(w, http.StatusOK, envelope{"billings": billings, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkCustomerInScope makes sure the customer exists and is visible to the current
+// user, sending the error response and returning false otherwise.
+func (app *application) checkCustomerInScope(w http.ResponseWriter, r *http.Request, customerID int64) bool {
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	_, err = app.models.Customers.Get(customerID, scope)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v := validator.New()
+			v.AddError("customer_id", "customer does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+	return true
+}
+
+// listBillingsAfter sends one page of billing entries in keyset mode, together with
+// the cursor of the next page.
+func (app *application) listBillingsAfter(w http.ResponseWriter, r *http.Request, customerID int64, filters data.CursorFilters, v *validator.Validator) {
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	billings, next, err := app.models.Billing.GetAllAfter(customerID, scope, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var nextCursor *string
+	if next != nil {
+		encoded := next.Encode()
+		nextCursor = &encoded
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"billings": billings, "next_cursor": nextCursor}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
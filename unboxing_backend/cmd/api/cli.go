@@ -0,0 +1,200 @@
+package main
+
+import (
+	"company/internal/data"
+	"company/internal/validator"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// createAdminCommand implements the `api create-admin` subcommand, which creates an
+// activated Administrator directly in the database. It is the way to bootstrap a
+// deployment without going through the setup key, or to regain access when every
+// administrator has been locked out.
+func createAdminCommand(args []string) error {
+	var (
+		cfg      config
+		name     string
+		email    string
+		password string
+	)
+	fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	fs.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("COMPANY_DB_DSN"), "POSTGRESQL DSN")
+	fs.StringVar(&name, "name", "", "Administrator name")
+	fs.StringVar(&email, "email", "", "Administrator email")
+	fs.StringVar(&password, "password", os.Getenv("COMPANY_ADMIN_PASSWORD"), "Administrator password")
+	fs.Parse(args)
+
+	user := &data.User{
+		Name:      name,
+		Email:     email,
+		Role:      "Administrator",
+		Activated: true,
+	}
+	v := validator.New()
+	data.ValidateUser(v, user)
+	data.ValidatePasswordPlaintext(v, password)
+	if !v.Valid() {
+		for field, message := range v.Errors {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", field, message)
+		}
+		return errors.New("invalid administrator details")
+	}
+	err := user.Password.Set(password)
+	if err != nil {
+		return err
+	}
+
+	app := application{
+		config:      cfg,
+		infoLogger:  log.New(os.Stdout, "INFO ", log.Ldate|log.Ltime),
+		errorLogger: log.New(os.Stderr, "ERROR ", log.Ldate|log.Ltime|log.Lshortfile),
+	}
+	db, err := app.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	app.models = data.NewModels(db)
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return err
+	}
+	// The setup key is of no use anymore now that an administrator exists
+	err = app.models.SetupKeys.DeleteAll()
+	if err != nil {
+		return err
+	}
+	app.infoLogger.Printf("administrator %s created with ID %d", user.Email, user.ID)
+	return nil
+}
+
+// setupKeyCommand implements the `api setup-key` subcommand, which generates the
+// one-time key to register the first administrator through /admin/register. Any
+// previous key stops working. The key is written to stdout only, and never logged.
+func setupKeyCommand(args []string) error {
+	var cfg config
+	fs := flag.NewFlagSet("setup-key", flag.ExitOnError)
+	fs.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("COMPANY_DB_DSN"), "POSTGRESQL DSN")
+	fs.Parse(args)
+
+	app := application{
+		config:      cfg,
+		infoLogger:  log.New(os.Stdout, "INFO ", log.Ldate|log.Ltime),
+		errorLogger: log.New(os.Stderr, "ERROR ", log.Ldate|log.Ltime|log.Lshortfile),
+	}
+	db, err := app.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	app.models = data.NewModels(db)
+
+	exists, err := app.models.Users.AdministratorExists()
+	if err != nil {
+		return err
+	}
+	if exists {
+		return data.ErrSetupCompleted
+	}
+	key, err := app.models.SetupKeys.New()
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
+
+// loadExchangeRatesCommand implements the `api load-exchange-rates` subcommand, which
+// loads the exchange rates of a CSV file, see data.ParseExchangeRatesCSV for its
+// format. It is meant to be run from cron with the rates published every day.
+func loadExchangeRatesCommand(args []string) error {
+	var (
+		cfg  config
+		path string
+	)
+	fs := flag.NewFlagSet("load-exchange-rates", flag.ExitOnError)
+	fs.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("COMPANY_DB_DSN"), "POSTGRESQL DSN")
+	fs.StringVar(&cfg.baseCurrency, "base-currency", "INR", "Currency of the rates without a base_currency column")
+	fs.StringVar(&path, "file", "", "CSV file with the rates")
+	fs.Parse(args)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	rates, err := data.ParseExchangeRatesCSV(file, cfg.baseCurrency)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	app := application{
+		config:      cfg,
+		infoLogger:  log.New(os.Stdout, "INFO ", log.Ldate|log.Ltime),
+		errorLogger: log.New(os.Stderr, "ERROR ", log.Ldate|log.Ltime|log.Lshortfile),
+	}
+	db, err := app.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	app.models = data.NewModels(db)
+
+	err = app.models.Rates.Insert(rates)
+	if err != nil {
+		return err
+	}
+	app.infoLogger.Printf("%d exchange rates loaded from %s", len(rates), path)
+	return nil
+}
+
+// assignCustomersCommand implements the `api assign-customers` subcommand, which makes
+// a Sales user the account manager of every customer without one. Customers created
+// before account managers existed have none, and Sales users can't see them until
+// they are assigned.
+func assignCustomersCommand(args []string) error {
+	var (
+		cfg   config
+		email string
+	)
+	fs := flag.NewFlagSet("assign-customers", flag.ExitOnError)
+	fs.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("COMPANY_DB_DSN"), "POSTGRESQL DSN")
+	fs.StringVar(&email, "email", "", "Email of the account manager")
+	fs.Parse(args)
+
+	app := application{
+		config:      cfg,
+		infoLogger:  log.New(os.Stdout, "INFO ", log.Ldate|log.Ltime),
+		errorLogger: log.New(os.Stderr, "ERROR ", log.Ldate|log.Ltime|log.Lshortfile),
+	}
+	db, err := app.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	app.models = data.NewModels(db)
+
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		return fmt.Errorf("account manager %q: %w", email, err)
+	}
+	v := validator.New()
+	if err = app.validateAccountManager(v, user.ID); err != nil {
+		return err
+	}
+	if !v.Valid() {
+		return fmt.Errorf("account manager %q: %s", email, v.Errors["account_manager_id"])
+	}
+
+	assigned, err := app.models.Customers.AssignUnmanaged(user.ID)
+	if err != nil {
+		return err
+	}
+	app.infoLogger.Printf("%d customers assigned to %s", assigned, user.Email)
+	return nil
+}
@@ -8,7 +8,10 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey  = contextKey("user")
+	tokenContextKey = contextKey("token")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -26,3 +29,17 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// The contextSetToken() method stores the plaintext authentication token that was
+// used to authenticate the request, so that handlers such as logout can revoke it.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// The contextGetToken() retrieves the plaintext authentication token from the request
+// context. It returns an empty string for anonymous requests.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"company/internal/data"
+	"company/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// createCreditNoteHandler issues a credit note against the invoice of a billing
+// entry, to correct it without deleting it. The part of the credit note more than
+// what is left to pay is refunded to the customer. Credit notes are dated the day they
+// are issued.
+func (app *application) createCreditNoteHandler(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := app.readInvoiceFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Amount  data.Money `json:"amount"`
+		Reason  string     `json:"reason"`
+		Version *int32     `json:"version"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !app.checkInvoiceVersion(w, r, invoice, input.Version) {
+		return
+	}
+	if !invoice.Creditable() {
+		message := fmt.Sprintf("credit notes can't be issued against a %s invoice", invoice.Status)
+		app.errorResponse(w, r, http.StatusConflict, message)
+		return
+	}
+
+	note := &data.CreditNote{
+		Amount: input.Amount,
+		Reason: input.Reason,
+	}
+	v := validator.New()
+	if data.ValidateCreditNote(v, note, invoice); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.CreditNotes.Insert(note, invoice)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/billing/%d/credit-notes", invoice.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"credit_note": note, "invoice": invoice}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listCreditNotesHandler(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := app.readInvoiceFromPath(w, r)
+	if !ok {
+		return
+	}
+	notes, err := app.models.CreditNotes.GetAllForInvoice(invoice.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"credit_notes": notes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -10,6 +10,45 @@ import (
 	"strconv"
 )
 
+// customerScope returns the customers the current user may see and edit: all of them
+// with the view_all_customers permission, otherwise only the customers they manage.
+func (app *application) customerScope(r *http.Request) (data.CustomerScope, error) {
+	user := app.contextGetUser(r)
+	permissions, err := app.models.Permissions.GetAllForUser(user)
+	if err != nil {
+		return data.CustomerScope{}, err
+	}
+	if permissions.Include("view_all_customers") {
+		return data.CustomerScope{}, nil
+	}
+	return data.CustomerScope{AccountManagerID: user.ID}, nil
+}
+
+// validateAccountManager checks the user given as the account manager of a customer
+// exists, is activated and can manage customers, adding the error to v otherwise.
+func (app *application) validateAccountManager(v *validator.Validator, id int64) error {
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("account_manager_id", "must be an existing user")
+			return nil
+		default:
+			return err
+		}
+	}
+	if !user.Activated {
+		v.AddError("account_manager_id", "must be an activated user")
+		return nil
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(user)
+	if err != nil {
+		return err
+	}
+	v.Check(permissions.Include("manage_customers"), "account_manager_id", "must be a user who manages customers")
+	return nil
+}
+
 func (app *application) showCustomerHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	numID, err := strconv.Atoi(id)
@@ -18,7 +57,12 @@ func (app *application) showCustomerHandler(w http.ResponseWriter, r *http.Reque
 		http.Error(w, "Can't get ID", http.StatusBadRequest)
 		return
 	}
-	customer, err := app.models.Customers.Get(int64(numID))
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	customer, err := app.models.Customers.Get(int64(numID), scope)
 	if err != nil {
 		app.errorLogger.Println("Unable to get customer of this ID", err)
 		http.Error(w, "Customer not found", http.StatusNotFound)
@@ -36,10 +80,13 @@ func (app *application) showCustomerHandler(w http.ResponseWriter, r *http.Reque
 
 func (app *application) createCustomerHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name    string `json:"name"`
-		Email   string `json:"email"`
-		Phone   string `json:"info"`
-		Address string `json:"address"`
+		Name             string `json:"name"`
+		Email            string `json:"email"`
+		Phone            string `json:"info"`
+		Address          string `json:"address"`
+		AccountManagerID *int64 `json:"account_manager_id"`
+		TaxTreatment     string `json:"tax_treatment"`
+		TaxID            string `json:"tax_id"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&input)
@@ -48,11 +95,43 @@ func (app *application) createCustomerHandler(w http.ResponseWriter, r *http.Req
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 	newCustomer := data.Customer{
-		Name:    input.Name,
-		Email:   input.Email,
-		Phone:   input.Phone,
-		Address: input.Address,
+		Name:             input.Name,
+		Email:            input.Email,
+		Phone:            input.Phone,
+		Address:          input.Address,
+		AccountManagerID: input.AccountManagerID,
+		TaxTreatment:     input.TaxTreatment,
+		TaxID:            input.TaxID,
+	}
+	if newCustomer.TaxTreatment == "" {
+		newCustomer.TaxTreatment = data.TaxStandard
+	}
+	// Customers created by a Sales user are managed by them, only users who see all
+	// customers can hand a new customer to somebody else
+	if scope.AccountManagerID != 0 {
+		if input.AccountManagerID != nil && *input.AccountManagerID != scope.AccountManagerID {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		newCustomer.AccountManagerID = &scope.AccountManagerID
+	}
+	v := validator.New()
+	data.ValidateCustomerTax(v, &newCustomer)
+	if input.AccountManagerID != nil && scope.AccountManagerID == 0 {
+		if err = app.validateAccountManager(v, *input.AccountManagerID); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 	if err := app.models.Customers.Insert(&newCustomer); err != nil {
 		app.errorLogger.Println("Inserting customer into database", err)
@@ -78,7 +157,12 @@ func (app *application) updateCustomerHandler(w http.ResponseWriter, r *http.Req
 		http.Error(w, "Can't get ID", http.StatusBadRequest)
 		return
 	}
-	customer, err := app.models.Customers.Get(int64(numID))
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	customer, err := app.models.Customers.Get(int64(numID), scope)
 	if err != nil {
 		if err == data.ErrRecordNotFound {
 			app.errorLogger.Println("Getting customer", err)
@@ -91,10 +175,13 @@ func (app *application) updateCustomerHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	var input struct {
-		Name    *string `json:"name"`
-		Email   *string `json:"email"`
-		Phone   *string `json:"phone"`
-		Address *string `json:"address"`
+		Name             *string `json:"name"`
+		Email            *string `json:"email"`
+		Phone            *string `json:"phone"`
+		Address          *string `json:"address"`
+		AccountManagerID *int64  `json:"account_manager_id"`
+		TaxTreatment     *string `json:"tax_treatment"`
+		TaxID            *string `json:"tax_id"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	err = decoder.Decode(&input)
@@ -116,6 +203,32 @@ func (app *application) updateCustomerHandler(w http.ResponseWriter, r *http.Req
 	if input.Address != nil {
 		customer.Address = *input.Address
 	}
+	if input.AccountManagerID != nil {
+		// Only users who see all customers can reassign them
+		if scope.AccountManagerID != 0 {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		customer.AccountManagerID = input.AccountManagerID
+	}
+	if input.TaxTreatment != nil {
+		customer.TaxTreatment = *input.TaxTreatment
+	}
+	if input.TaxID != nil {
+		customer.TaxID = *input.TaxID
+	}
+	v := validator.New()
+	data.ValidateCustomerTax(v, customer)
+	if input.AccountManagerID != nil {
+		if err = app.validateAccountManager(v, *input.AccountManagerID); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 	err = app.models.Customers.Update(customer)
 	if err != nil {
 		switch {
@@ -142,7 +255,12 @@ func (app *application) deleteCustomerHandler(w http.ResponseWriter, r *http.Req
 		http.Error(w, "Can't get ID", http.StatusBadRequest)
 		return
 	}
-	err = app.models.Customers.Delete(int64(numID))
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Customers.Delete(int64(numID), scope)
 	if err == data.ErrRecordNotFound {
 		app.errorLogger.Println("Customer ID not found", err)
 		http.Error(w, "Data not found", http.StatusNotFound)
@@ -160,36 +278,58 @@ func (app *application) deleteCustomerHandler(w http.ResponseWriter, r *http.Req
 func (app *application) listCustomersHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Name    string
+		Query   string
 		Filters data.Filters
 	}
 	queryString := r.URL.Query()
+	v := validator.New()
 
-	var name string
-	defaultName := ""
-	nameFromQuery := queryString.Get("name")
-	if nameFromQuery == "" {
-		name = defaultName
-	} else {
-		name = nameFromQuery
-	}
-	input.Name = name
-	v := &validator.Validator{}
+	input.Name = app.readString(queryString, "name", "")
+	input.Query = app.readString(queryString, "q", "")
 	input.Filters.Page = app.readInt(queryString, "page", 1, v)
 	input.Filters.PageSize = app.readInt(queryString, "page_size", 20, v)
 	input.Filters.Sort = app.readString(queryString, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at",
+		"-id", "-name", "-email", "-created_at"}
+
+	// Searching returns the most relevant customers first unless told otherwise
+	var searchQuery string
+	if input.Query != "" {
+		searchQuery = data.SearchQuery(input.Query)
+		v.Check(searchQuery != "", "q", "must contain at least one word")
+		input.Filters.Sort = app.readString(queryString, "sort", "-rank")
+		input.Filters.SortSafelist = append(input.Filters.SortSafelist, "rank", "-rank")
+	}
 
-	customers, err := app.models.Customers.GetAll()
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scope, err := app.customerScope(r)
 	if err != nil {
-		app.errorLogger.Println("Getting customers", err)
-		http.Error(w, "Error when getting customers", http.StatusInternalServerError)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if searchQuery != "" {
+		results, metadata, err := app.models.Customers.Search(searchQuery, scope, input.Filters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{"customers": results, "metadata": metadata}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	js, err := json.MarshalIndent(customers, "", "\t")
+	customers, metadata, err := app.models.Customers.GetAll(input.Name, scope, input.Filters)
 	if err != nil {
-		app.errorLogger.Println("Marshalling customer, converting to JSON", err)
-		http.Error(w, "Error when getting customers", http.StatusInternalServerError)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.Write(js)
+	err = app.writeJSON(w, http.StatusOK, envelope{"customers": customers, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -56,7 +56,22 @@ func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) invoiceNotEditableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "only draft invoices can be changed or deleted, void issued invoices instead"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
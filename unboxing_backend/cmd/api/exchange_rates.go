@@ -0,0 +1,59 @@
+package main
+
+import (
+	"company/internal/data"
+	"company/internal/validator"
+	"net/http"
+	"strings"
+)
+
+// listExchangeRatesHandler returns the loaded rates into the base currency, the most
+// recent first, optionally only those of the currency in the query string.
+func (app *application) listExchangeRatesHandler(w http.ResponseWriter, r *http.Request) {
+	queryString := r.URL.Query()
+	v := validator.New()
+
+	currency := strings.ToUpper(app.readString(queryString, "currency", ""))
+	filters := data.Filters{
+		Page:         app.readInt(queryString, "page", 1, v),
+		PageSize:     app.readInt(queryString, "page_size", 20, v),
+		Sort:         "-effective_on",
+		SortSafelist: []string{"-effective_on"},
+	}
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rates, metadata, err := app.models.Rates.GetAll(currency, app.config.baseCurrency, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"exchange_rates": rates, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// loadExchangeRatesHandler loads the exchange rates of a CSV file sent as the request
+// body, see data.ParseExchangeRatesCSV for its format. Rates already loaded for the
+// same currency and date are replaced.
+func (app *application) loadExchangeRatesHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	rates, err := data.ParseExchangeRatesCSV(r.Body, app.config.baseCurrency)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	err = app.models.Rates.Insert(rates)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"loaded": len(rates)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"company/internal/data"
 	"company/internal/validator"
 	"encoding/json"
 	"errors"
@@ -11,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 )
 
 type envelope map[string]interface{}
@@ -107,6 +109,55 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// The readDate() helper reads a YYYY-MM-DD date from the query string, or returns the
+// provided default value if no matching key could be found.
+func (app *application) readDate(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	date, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		v.AddError(key, "must be a date formatted as YYYY-MM-DD")
+		return defaultValue
+	}
+	return date
+}
+
+// The readCursorFilters() helper reads the keyset pagination parameters "after" and
+// "limit" from the query string. The boolean result is false when neither is present,
+// in which case the client asked for the page-based mode.
+func (app *application) readCursorFilters(qs url.Values, v *validator.Validator) (data.CursorFilters, bool) {
+	if !qs.Has("after") && !qs.Has("limit") {
+		return data.CursorFilters{}, false
+	}
+	filters := data.CursorFilters{
+		Limit: app.readInt(qs, "limit", 20, v),
+	}
+	if after := qs.Get("after"); after != "" {
+		cursor, err := data.DecodeCursor(after)
+		if err != nil {
+			v.AddError("after", "must be a cursor returned by a previous request")
+		}
+		filters.After = cursor
+	}
+	data.ValidateCursorFilters(v, filters)
+	return filters, true
+}
+
+// The background() helper runs fn in a new goroutine, recovering from any panic so
+// that a failure in background work (like sending an email) can't crash the server.
+func (app *application) background(fn func()) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				app.errorLogger.Println(fmt.Errorf("%s", err))
+			}
+		}()
+		fn()
+	}()
+}
+
 // Helper function to parse templates
 func (app *application) parseTemplate(base string, pages ...string) *template.Template {
 	tmpl, err := template.ParseFiles(append([]string{base}, pages...)...)
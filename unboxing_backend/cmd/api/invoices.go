@@ -0,0 +1,371 @@
+package main
+
+import (
+	"company/internal/data"
+	"company/internal/validator"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// invoiceLineInput is an invoice line as sent by clients. The unit price is a decimal
+// amount in the currency of the invoice, and the numbers may be sent either as JSON
+// numbers or as strings. Lines are taxed either at the rate of a tax category or at
+// an explicit rate.
+type invoiceLineInput struct {
+	Description string      `json:"description"`
+	Quantity    json.Number `json:"quantity"`
+	UnitPrice   json.Number `json:"unit_price"`
+	TaxCategory string      `json:"tax_category"`
+	TaxRate     json.Number `json:"tax_rate"`
+}
+
+// invoiceLines turns the lines sent by a client into invoice lines in the currency,
+// recording the unit prices which can't be parsed in the validator.
+func (app *application) invoiceLines(v *validator.Validator, inputs []invoiceLineInput, currency string) []*data.InvoiceLine {
+	lines := make([]*data.InvoiceLine, 0, len(inputs))
+	for n, input := range inputs {
+		line := &data.InvoiceLine{
+			Description: input.Description,
+			Quantity:    input.Quantity.String(),
+			TaxCategory: input.TaxCategory,
+			TaxRate:     input.TaxRate.String(),
+		}
+		if line.TaxCategory != "" && line.TaxRate != "" {
+			v.AddError(fmt.Sprintf("lines[%d].tax_rate", n), "must not be provided with a tax category")
+		}
+		if line.Quantity == "" {
+			line.Quantity = "1"
+		}
+		if line.TaxRate == "" {
+			line.TaxRate = "0"
+		}
+		unitPrice, err := data.ParseMoney(input.UnitPrice.String(), currency)
+		if err != nil {
+			// An unknown currency is reported once, by the validation of the invoice
+			if data.ValidCurrency(currency) {
+				v.AddError(fmt.Sprintf("lines[%d].unit_price", n), "must be a decimal amount in the currency of the invoice")
+			}
+			unitPrice = data.Money{Currency: currency}
+		}
+		line.UnitPrice = unitPrice
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// readInvoiceFromPath fetches the invoice whose ID is in the URL path, sending the
+// error response itself when it fails.
+func (app *application) readInvoiceFromPath(w http.ResponseWriter, r *http.Request) (*data.Invoice, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	invoice, err := app.models.Invoices.Get(id, scope)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return invoice, true
+}
+
+// checkInvoiceVersion makes sure the invoice didn't change since the client read it,
+// sending an edit conflict response when it did. Clients may send the version of the
+// invoice or billing entry they read along with changes; without it the check passes.
+func (app *application) checkInvoiceVersion(w http.ResponseWriter, r *http.Request, invoice *data.Invoice, version *int32) bool {
+	if version != nil && *version != invoice.Version {
+		app.editConflictResponse(w, r)
+		return false
+	}
+	return true
+}
+
+func (app *application) listInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CustomerID int64
+		Status     string
+		Filters    data.Filters
+	}
+	queryString := r.URL.Query()
+	v := validator.New()
+
+	input.CustomerID = int64(app.readInt(queryString, "customer_id", 0, v))
+	input.Status = app.readString(queryString, "status", "")
+	input.Filters.Page = app.readInt(queryString, "page", 1, v)
+	input.Filters.PageSize = app.readInt(queryString, "page_size", 20, v)
+	input.Filters.Sort = app.readString(queryString, "sort", "-issue_date")
+	input.Filters.SortSafelist = []string{"id", "number", "issue_date", "due_date", "total",
+		"-id", "-number", "-issue_date", "-due_date", "-total"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	invoices, metadata, err := app.models.Invoices.GetAll(input.CustomerID, input.Status, scope, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"invoices": invoices, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createInvoiceHandler creates a draft invoice, numbered once issued. The series
+// defaults to the configured one, the currency to the base currency, the issue date
+// to today and the due date to the default payment terms.
+func (app *application) createInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CustomerID int64              `json:"customer_id"`
+		Series     string             `json:"series"`
+		Currency   string             `json:"currency"`
+		IssueDate  *Date              `json:"issue_date"`
+		DueDate    *Date              `json:"due_date"`
+		Lines      []invoiceLineInput `json:"lines"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	invoice := &data.Invoice{
+		Series:     input.Series,
+		CustomerID: input.CustomerID,
+		Currency:   input.Currency,
+		IssueDate:  time.Now().UTC().Truncate(24 * time.Hour),
+		Status:     data.InvoiceDraft,
+	}
+	if invoice.Series == "" {
+		invoice.Series = app.models.Invoices.Numbering.DefaultInvoiceSeries()
+	}
+	if invoice.Currency == "" {
+		invoice.Currency = app.config.baseCurrency
+	}
+	if input.IssueDate != nil {
+		invoice.IssueDate = input.IssueDate.Time
+	}
+	invoice.DueDate = invoice.IssueDate.AddDate(0, 0, data.DefaultPaymentTerms)
+	if input.DueDate != nil {
+		invoice.DueDate = input.DueDate.Time
+	}
+
+	v := validator.New()
+	invoice.Lines = app.invoiceLines(v, input.Lines, invoice.Currency)
+	if data.ValidateInvoice(v, invoice, app.models.Invoices.Numbering); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if ok := app.checkCustomerInScope(w, r, invoice.CustomerID); !ok {
+		return
+	}
+	if err = invoice.CalculateTotals(); err != nil {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	err = app.models.Invoices.Insert(invoice)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownTaxCategory):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/invoices/%d", invoice.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"invoice": invoice}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := app.readInvoiceFromPath(w, r)
+	if !ok {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"invoice": invoice}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateInvoiceHandler changes the fields sent by the client. Lines, when sent,
+// replace all the lines of the invoice. Only drafts can be changed.
+func (app *application) updateInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := app.readInvoiceFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !invoice.Editable() {
+		app.invoiceNotEditableResponse(w, r)
+		return
+	}
+
+	var input struct {
+		CustomerID *int64             `json:"customer_id"`
+		Series     *string            `json:"series"`
+		Currency   *string            `json:"currency"`
+		IssueDate  *Date              `json:"issue_date"`
+		DueDate    *Date              `json:"due_date"`
+		Lines      []invoiceLineInput `json:"lines"`
+		Version    *int32             `json:"version"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !app.checkInvoiceVersion(w, r, invoice, input.Version) {
+		return
+	}
+
+	v := validator.New()
+	if input.CustomerID != nil {
+		invoice.CustomerID = *input.CustomerID
+	}
+	if input.Series != nil {
+		invoice.Series = *input.Series
+	}
+	if input.Currency != nil {
+		invoice.Currency = *input.Currency
+		// Lines have to be sent again with prices in the new currency
+		v.Check(input.Lines != nil, "lines", "must be provided when changing the currency")
+	}
+	if input.IssueDate != nil {
+		invoice.IssueDate = input.IssueDate.Time
+	}
+	if input.DueDate != nil {
+		invoice.DueDate = input.DueDate.Time
+	}
+	if input.Lines != nil {
+		invoice.Lines = app.invoiceLines(v, input.Lines, invoice.Currency)
+	}
+
+	if data.ValidateInvoice(v, invoice, app.models.Invoices.Numbering); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if input.CustomerID != nil {
+		if ok := app.checkCustomerInScope(w, r, invoice.CustomerID); !ok {
+			return
+		}
+	}
+	if err = invoice.CalculateTotals(); err != nil {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	err = app.models.Invoices.Update(invoice)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrUnknownTaxCategory):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"invoice": invoice}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := app.readInvoiceFromPath(w, r)
+	if !ok {
+		return
+	}
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Invoices.Delete(invoice.ID, scope)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrInvoiceNotEditable):
+			app.invoiceNotEditableResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "invoice successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// transitionInvoiceHandler returns a handler moving the invoice in the URL path to the
+// status, e.g. issuing or voiding it.
+func (app *application) transitionInvoiceHandler(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		invoice, ok := app.readInvoiceFromPath(w, r)
+		if !ok {
+			return
+		}
+		var input struct {
+			Version *int32 `json:"version"`
+		}
+		if r.ContentLength != 0 {
+			err := app.readJSON(w, r, &input)
+			if err != nil {
+				app.badRequestResponse(w, r, err)
+				return
+			}
+		}
+		if !app.checkInvoiceVersion(w, r, invoice, input.Version) {
+			return
+		}
+
+		err := app.models.Invoices.Transition(invoice, status)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrInvalidTransition):
+				message := fmt.Sprintf("a %s invoice can't be moved to %s", invoice.Status, status)
+				app.errorResponse(w, r, http.StatusConflict, message)
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			case errors.Is(err, data.ErrUnknownTaxCategory):
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		err = app.writeJSON(w, http.StatusOK, envelope{"invoice": invoice}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
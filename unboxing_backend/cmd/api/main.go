@@ -2,8 +2,10 @@ package main
 
 import (
 	"company/internal/data"
+	"company/internal/mailer"
 	"context"
 	"database/sql"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
@@ -22,11 +24,37 @@ const version = "1.0.0"
 // application (development, staging, production, etc.). We will read in these
 // configuration settings from command-line flags when the application starts.
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port        int
+	env         string
+	frontendURL string
+	db          struct {
 		dsn string
 	}
+	permissionCacheTTL time.Duration
+	baseCurrency       string
+	tokens             struct {
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+	mailer struct {
+		mode string
+		dir  string
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	numbering struct {
+		invoiceSeries    string
+		creditNoteSeries string
+		fiscalYearStart  int
+	}
+	scheduler struct {
+		interval time.Duration
+	}
 }
 
 // Define an application struct to hold the dependencies for our HTTP handlers, helpers,
@@ -37,9 +65,36 @@ type application struct {
 	errorLogger *log.Logger
 	infoLogger  *log.Logger
 	models      data.Models
+	mailer      mailer.Mailer
 }
 
 func main() {
+	//subcommands are handled before the server flags are parsed
+	if len(os.Args) > 1 && os.Args[1] == "create-admin" {
+		if err := createAdminCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "setup-key" {
+		if err := setupKeyCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "load-exchange-rates" {
+		if err := loadExchangeRatesCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "assign-customers" {
+		if err := assignCustomersCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	//declate an instance of config struct
 	var cfg config
 
@@ -50,7 +105,35 @@ func main() {
 	flag.StringVar(&cfg.env, "env", "development",
 		"Environment (development|staging|production)")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("COMPANY_DB_DSN"), "POSTGRESQL DSN")
+	flag.StringVar(&cfg.frontendURL, "frontend-url", "http://localhost:5500", "Base URL of the frontend, used for links in emails")
+	flag.DurationVar(&cfg.permissionCacheTTL, "permission-cache-ttl", time.Minute, "How long role permissions are cached")
+	flag.DurationVar(&cfg.tokens.accessTTL, "access-token-ttl", 24*time.Hour, "Authentication token lifetime")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "Refresh token lifetime")
+	flag.StringVar(&cfg.baseCurrency, "base-currency", "INR", "ISO-4217 currency reports are converted into")
+	flag.StringVar(&cfg.numbering.invoiceSeries, "invoice-series", "INV", "Numbering series of invoices created without one")
+	flag.StringVar(&cfg.numbering.creditNoteSeries, "credit-note-series", "CN", "Numbering series of credit notes")
+	flag.IntVar(&cfg.numbering.fiscalYearStart, "fiscal-year-start", 4, "First month (1-12) of the fiscal year invoices are numbered in")
+	flag.DurationVar(&cfg.scheduler.interval, "billing-scheduler-interval", 15*time.Minute, "How often due subscriptions are billed (0 disables billing them)")
+	flag.StringVar(&cfg.mailer.mode, "mailer", "file", "Mailer (smtp|file|memory)")
+	flag.StringVar(&cfg.mailer.dir, "mailer-dir", "./tmp/mail", "Directory the file mailer writes emails to")
+	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("COMPANY_SMTP_HOST"), "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("COMPANY_SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("COMPANY_SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Company <no-reply@company.local>", "SMTP sender")
 	flag.Parse()
+	if !data.ValidCurrency(cfg.baseCurrency) {
+		log.Fatalf("unsupported base currency %q", cfg.baseCurrency)
+	}
+	if !data.SeriesRX.MatchString(cfg.numbering.invoiceSeries) {
+		log.Fatalf("invalid invoice series %q", cfg.numbering.invoiceSeries)
+	}
+	if !data.SeriesRX.MatchString(cfg.numbering.creditNoteSeries) || cfg.numbering.creditNoteSeries == cfg.numbering.invoiceSeries {
+		log.Fatalf("invalid credit note series %q", cfg.numbering.creditNoteSeries)
+	}
+	if cfg.numbering.fiscalYearStart < 1 || cfg.numbering.fiscalYearStart > 12 {
+		log.Fatalf("invalid fiscal year start month %d", cfg.numbering.fiscalYearStart)
+	}
 
 	//logger to write message to stdout
 	infoLogger := log.New(os.Stdout, "INFO ", log.Ldate|log.Ltime)
@@ -61,6 +144,16 @@ func main() {
 		infoLogger:  infoLogger,
 		errorLogger: errorLogger,
 	}
+	switch cfg.mailer.mode {
+	case "smtp":
+		app.mailer = mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+	case "file":
+		app.mailer = mailer.NewFile(cfg.mailer.dir, cfg.smtp.sender)
+	case "memory":
+		app.mailer = mailer.NewMemory(cfg.smtp.sender)
+	default:
+		errorLogger.Fatalf("unknown mailer %q", cfg.mailer.mode)
+	}
 	// Load templates
 
 	//connect to database, and open a connection
@@ -69,17 +162,42 @@ func main() {
 		errorLogger.Fatal(err)
 	}
 	app.models = data.NewModels(db) //is it ok to have a circular dependency here
+	app.models.Permissions.Cache = data.NewPermissionCache(cfg.permissionCacheTTL)
+	numbering := data.Numbering{
+		InvoiceSeries:    cfg.numbering.invoiceSeries,
+		CreditNoteSeries: cfg.numbering.creditNoteSeries,
+		FiscalYearStart:  time.Month(cfg.numbering.fiscalYearStart),
+	}
+	app.models.Invoices.Numbering = numbering
+	app.models.Billing.Numbering = numbering
+	app.models.Subscriptions.Numbering = numbering
+	app.models.CreditNotes.Numbering = numbering
+	expvar.Publish("permission_cache", expvar.Func(func() interface{} {
+		return app.models.Permissions.Cache.Stats()
+	}))
 	// Defer a call to db.Close() so that the connection pool is closed before the
 	// main() function exits.
 	defer db.Close()
 	// Also log a message to say that the connection pool has been successfully
 	// established.
 	infoLogger.Printf("database connection pool established")
+	if err := app.prepareSetupKey(); err != nil {
+		errorLogger.Fatal(err)
+	}
 	router := http.NewServeMux()
 	app.enableCORS(router)
 	router.HandleFunc("GET /healthcheck", app.healthcheckHandler)
-	router.HandleFunc(("/tokens/authentication"), app.createAuthenticationTokenHandler)
-	router.HandleFunc("POST /admin/register", app.registerAdminHandler)
+	router.HandleFunc("GET /debug/vars", app.requirePermission("manage_employee", expvar.Handler().ServeHTTP))
+	router.HandleFunc("DELETE /tokens/authentication",
+		app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler)) //logout
+	router.HandleFunc("DELETE /tokens/authentication/all",
+		app.requireAuthenticatedUser(app.deleteAllAuthenticationTokensHandler)) //logout everywhere
+
+	//the currently authenticated user
+	router.HandleFunc("GET /v1/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandleFunc("GET /v1/me/payroll", app.requireAuthenticatedUser(app.showCurrentUserPayrollHandler))
+	router.HandleFunc("GET /v1/me/profile", app.requireAuthenticatedUser(app.showCurrentUserProfileHandler))
+	router.HandleFunc("PATCH /v1/me/profile", app.requireAuthenticatedUser(app.updateCurrentUserProfileHandler))
 
 	//user management done by Adminstrator
 	router.HandleFunc("GET /v1/user",
@@ -92,19 +210,67 @@ func main() {
 		app.requirePermission("manage_employee", app.updateUserHandler))
 	router.HandleFunc("DELETE /v1/user/{id}",
 		app.requirePermission("manage_employee", app.deleteUserHandler))
+	router.HandleFunc("POST /v1/user/{id}/roles",
+		app.requirePermission("manage_employee", app.assignUserRoleHandler))
+	router.HandleFunc("DELETE /v1/user/{id}/roles/{role}",
+		app.requirePermission("manage_employee", app.unassignUserRoleHandler))
+	//roles and permissions, managed by Adminstrator
+	router.HandleFunc("GET /v1/permissions", app.requirePermission("manage_roles", app.listPermissionsHandler))
+	router.HandleFunc("GET /v1/roles", app.requirePermission("manage_roles", app.listRolesHandler))
+	router.HandleFunc("POST /v1/roles", app.requirePermission("manage_roles", app.createRoleHandler))
+	router.HandleFunc("POST /v1/roles/{name}/permissions", app.requirePermission("manage_roles", app.grantRolePermissionsHandler))
+	router.HandleFunc("DELETE /v1/roles/{name}/permissions/{permission}", app.requirePermission("manage_roles", app.revokeRolePermissionHandler))
+	router.HandleFunc("GET /v1/user/{id}/permissions", app.requirePermission("manage_roles", app.showUserPermissionsHandler))
+	router.HandleFunc("PUT /v1/user/{id}/permissions/{permission}", app.requirePermission("manage_roles", app.setUserPermissionHandler))
+	router.HandleFunc("DELETE /v1/user/{id}/permissions/{permission}", app.requirePermission("manage_roles", app.removeUserPermissionHandler))
 	//customers management done by Sales guy
 	router.HandleFunc("GET /v1/customer", app.requirePermission("manage_customers", app.listCustomersHandler))
 	router.HandleFunc("POST /v1/customer", app.requirePermission("manage_customers", app.createCustomerHandler))
 	router.HandleFunc("GET /v1/customer/{id}", app.requirePermission("manage_customers", app.showCustomerHandler))
 	router.HandleFunc("PATCH /v1/customer/{id}", app.requirePermission("manage_customers", app.updateCustomerHandler))
 	router.HandleFunc("DELETE /v1/customer/{id}", app.requirePermission("manage_customers", app.deleteCustomerHandler))
+	router.HandleFunc("GET /v1/customer/{id}/statement", app.requirePermission("view_billing", app.showCustomerStatementHandler))
 
-	//billing, accountants and sales guy can view it, but only sales guy can change it
+	//billing, accountants and sales guy can view it, but only sales guy can change it.
+	//new entries are drafts, which can be changed or deleted until they are issued
 	router.HandleFunc("GET /v1/billing", app.requirePermission("view_billing", app.listBillingsHandler))
 	router.HandleFunc("POST /v1/billing", app.requirePermission("manage_billing", app.createBillingHandler))
 	router.HandleFunc("GET /v1/billing/{id}", app.requirePermission("view_billing", app.showBillingHandler))
 	router.HandleFunc("PATCH /v1/billing/{id}", app.requirePermission("manage_billing", app.updateBillingHandler))
 	router.HandleFunc("DELETE /v1/billing/{id}", app.requirePermission("manage_billing", app.deleteBillingHandler))
+	router.HandleFunc("POST /v1/billing/{id}/issue",
+		app.requirePermission("manage_billing", app.transitionInvoiceHandler(data.InvoiceIssued)))
+	router.HandleFunc("GET /v1/billing/{id}/payments", app.requirePermission("view_billing", app.listPaymentsHandler))
+	router.HandleFunc("POST /v1/billing/{id}/payments", app.requirePermission("manage_billing", app.createPaymentHandler))
+	router.HandleFunc("GET /v1/billing/{id}/credit-notes", app.requirePermission("view_billing", app.listCreditNotesHandler))
+	router.HandleFunc("POST /v1/billing/{id}/credit-notes", app.requirePermission("manage_billing", app.createCreditNoteHandler))
+
+	//invoices, the billing entries above being a summary of them
+	router.HandleFunc("GET /v1/invoices", app.requirePermission("view_billing", app.listInvoicesHandler))
+	router.HandleFunc("POST /v1/invoices", app.requirePermission("manage_billing", app.createInvoiceHandler))
+	router.HandleFunc("GET /v1/invoices/{id}", app.requirePermission("view_billing", app.showInvoiceHandler))
+	router.HandleFunc("PATCH /v1/invoices/{id}", app.requirePermission("manage_billing", app.updateInvoiceHandler))
+	router.HandleFunc("DELETE /v1/invoices/{id}", app.requirePermission("manage_billing", app.deleteInvoiceHandler))
+	router.HandleFunc("POST /v1/invoices/{id}/issue",
+		app.requirePermission("manage_billing", app.transitionInvoiceHandler(data.InvoiceIssued)))
+	router.HandleFunc("POST /v1/invoices/{id}/void",
+		app.requirePermission("manage_billing", app.transitionInvoiceHandler(data.InvoiceVoid)))
+
+	//subscriptions, billed by the scheduler on every run date
+	router.HandleFunc("GET /v1/subscriptions", app.requirePermission("view_billing", app.listSubscriptionsHandler))
+	router.HandleFunc("POST /v1/subscriptions", app.requirePermission("manage_billing", app.createSubscriptionHandler))
+	router.HandleFunc("GET /v1/subscriptions/{id}", app.requirePermission("view_billing", app.showSubscriptionHandler))
+	router.HandleFunc("PATCH /v1/subscriptions/{id}", app.requirePermission("manage_billing", app.updateSubscriptionHandler))
+	router.HandleFunc("DELETE /v1/subscriptions/{id}", app.requirePermission("manage_billing", app.deleteSubscriptionHandler))
+
+	//exchange rates, tax rates and the reports accountants work with
+	router.HandleFunc("GET /v1/exchange-rates", app.requirePermission("view_billing", app.listExchangeRatesHandler))
+	router.HandleFunc("POST /v1/exchange-rates", app.requirePermission("manage_billing", app.loadExchangeRatesHandler))
+	router.HandleFunc("GET /v1/reports/billing", app.requirePermission("view_billing", app.billingReportHandler))
+	router.HandleFunc("GET /v1/reports/tax", app.requirePermission("view_billing", app.taxReportHandler))
+	router.HandleFunc("GET /v1/reports/receivables/aging", app.requirePermission("view_billing", app.receivablesAgingHandler))
+	router.HandleFunc("GET /v1/tax-rates", app.requirePermission("view_billing", app.listTaxRatesHandler))
+	router.HandleFunc("POST /v1/tax-rates", app.requirePermission("manage_taxes", app.createTaxRateHandler))
 
 	//payroll similarly accountants and HR can view it, but only HR can change it
 	router.HandleFunc("GET /v1/payroll", app.requirePermission("view_payroll", app.listPayrollsHandler))
@@ -113,18 +279,34 @@ func main() {
 	router.HandleFunc("PATCH /v1/payroll/{id}", app.requirePermission("manage_payroll", app.updatePayrollHandler))
 	router.HandleFunc("DELETE /v1/payroll/{id}", app.requirePermission("manage_payroll", app.deleteUserHandler))
 
-	//attaching middlewares
-	//router.Handle("/v1", app.authenticate(router))
+	//logging in, refreshing tokens, password reset and activation are open to everybody,
+	//outside of authenticate so that an expired token still sent in the Authorization
+	//header doesn't lock the client out of getting a new one
+	public := http.NewServeMux()
+	public.HandleFunc("POST /tokens/authentication", app.createAuthenticationTokenHandler)
+	public.HandleFunc("POST /tokens/refresh", app.refreshAuthenticationTokenHandler)
+	public.HandleFunc("POST /admin/register", app.registerAdminHandler)
+	public.HandleFunc("POST /v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	public.HandleFunc("PUT /v1/users/password", app.updateUserPasswordHandler)
+	public.HandleFunc("PUT /v1/users/activated", app.activateUserHandler)
+	//attaching middlewares, authenticate runs for every other request so that
+	//the user (or AnonymousUser) is always present in the request context
+	public.Handle("/", app.authenticate(router))
 	//declare a http with some good timeout settings. >>>>ich listens
 	//on the provided with port, and the above router as the handler
 	srv := &http.Server{
 		Addr:         fmt.Sprintf("localhost:%d", cfg.port),
-		Handler:      app.enableCORS(router),
+		Handler:      app.enableCORS(public),
 		IdleTimeout:  10 * time.Second,
 		ReadTimeout:  2 * time.Second,
 		WriteTimeout: 2 * time.Second,
 	}
 
+	//bill the due subscriptions in the background
+	if cfg.scheduler.interval > 0 {
+		go app.runBillingScheduler(cfg.scheduler.interval)
+	}
+
 	//Start the http server
 	infoLogger.Printf("starting the %s server on : %s", cfg.env, srv.Addr)
 	if err := srv.ListenAndServe(); err != nil {
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"company/internal/data"
+	"company/internal/validator"
+	"errors"
+	"net/http"
+)
+
+// showCurrentUserHandler returns the authenticated user together with the permissions
+// granted to them, so the frontend can decide which pages to show.
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	permissions, err := app.models.Permissions.GetAllForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showCurrentUserPayrollHandler lists the payroll entries of the authenticated user.
+// It only needs authentication, unlike /v1/payroll which shows everyone's salaries.
+func (app *application) showCurrentUserPayrollHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	var filters data.Filters
+	queryString := r.URL.Query()
+	v := validator.New()
+
+	// Keyset mode, used when the client passes "after" or "limit"
+	if cursorFilters, ok := app.readCursorFilters(queryString, v); ok {
+		app.listPayrollsAfter(w, r, user.ID, cursorFilters, v)
+		return
+	}
+
+	filters.Page = app.readInt(queryString, "page", 1, v)
+	filters.PageSize = app.readInt(queryString, "page_size", 20, v)
+	filters.Sort = app.readString(queryString, "sort", "-date")
+	filters.SortSafelist = []string{"id", "amount", "date", "-id", "-amount", "-date"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	payrolls, metadata, err := app.models.Payroll.GetAll(user.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"payrolls": payrolls, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCurrentUserProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateCurrentUserProfileHandler lets the authenticated user change their own name
+// and password. Changing the password needs the current password, so that somebody
+// who gets hold of an unlocked session can't take over the account, and logs the user
+// out of every other session.
+func (app *application) updateCurrentUserProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	var input struct {
+		Name            *string `json:"name"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if input.Name != nil {
+		user.Name = *input.Name
+		v.Check(user.Name != "", "name", "must be provided")
+		v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	}
+	if input.Password != nil {
+		data.ValidatePasswordPlaintext(v, *input.Password)
+		v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if input.Password != nil {
+		match, err := user.Password.Matches(input.CurrentPassword)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !match {
+			v.AddError("current_password", "is incorrect")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		err = user.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if input.Password != nil {
+		err = app.models.Token.DeleteOtherSessions(user.ID, app.contextGetToken(r))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -57,9 +57,16 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		// Users who haven't activated their account yet can't use it, even if they
+		// somehow got hold of a token.
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
 		// Call the contextSetUser() helper to add the user information to the request
-		// context.
+		// context, and keep the token around so that it can be revoked on logout.
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 		// Call the next handler in the chain.
 		next.ServeHTTP(w, r)
 	})
@@ -119,18 +126,21 @@ func (app *application) requireSales(next http.HandlerFunc) http.HandlerFunc {
 }
 
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	fn := func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
-		roleName := user.Role
-		//get all the permission for this specific role of user
-		permissions, err := app.models.Permissions.GetAllForRole(roleName)
+		//get the effective permissions of the user, from their roles and
+		//their own grants and denies
+		permissions, err := app.models.Permissions.GetAllForUser(user)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
+			return
 		}
 		if !permissions.Include(code) {
 			app.notPermittedResponse(w, r)
 			return
 		}
 		next.ServeHTTP(w, r)
-	})
+	}
+	// Anonymous users get a 401 before we ever look at their permissions.
+	return app.requireAuthenticatedUser(fn)
 }
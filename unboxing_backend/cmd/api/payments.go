@@ -0,0 +1,88 @@
+package main
+
+import (
+	"company/internal/data"
+	"company/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// createPaymentHandler records a payment against the invoice of a billing entry,
+// which becomes partially paid or paid.
+func (app *application) createPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := app.readInvoiceFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Amount    data.Money `json:"amount"`
+		PaidOn    *Date      `json:"paid_on"`
+		Method    string     `json:"method"`
+		Reference string     `json:"reference"`
+		Version   *int32     `json:"version"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !app.checkInvoiceVersion(w, r, invoice, input.Version) {
+		return
+	}
+	if !invoice.Payable() {
+		message := fmt.Sprintf("payments can't be recorded against a %s invoice", invoice.Status)
+		app.errorResponse(w, r, http.StatusConflict, message)
+		return
+	}
+
+	payment := &data.Payment{
+		Amount:    input.Amount,
+		PaidOn:    time.Now().UTC().Truncate(24 * time.Hour),
+		Method:    input.Method,
+		Reference: input.Reference,
+	}
+	if input.PaidOn != nil {
+		payment.PaidOn = input.PaidOn.Time
+	}
+	v := validator.New()
+	if data.ValidatePayment(v, payment, invoice); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Payments.Insert(payment, invoice)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/billing/%d/payments", invoice.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"payment": payment, "invoice": invoice}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := app.readInvoiceFromPath(w, r)
+	if !ok {
+		return
+	}
+	payments, err := app.models.Payments.GetAllForInvoice(invoice.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"payments": payments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
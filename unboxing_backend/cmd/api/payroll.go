@@ -36,9 +36,9 @@ func (app *application) showPayrollHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) createPayrollHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		EmployeeID int64   `json:"employee_id"`
-		Amount     float64 `json:"amount"`
-		Date       Date    `json:"date"`
+		EmployeeID int64      `json:"employee_id"`
+		Amount     data.Money `json:"amount"`
+		Date       Date       `json:"date"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&input)
@@ -47,6 +47,11 @@ func (app *application) createPayrollHandler(w http.ResponseWriter, r *http.Requ
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
+	v := validator.New()
+	if data.ValidateMoney(v, "amount", input.Amount); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 	newPayroll := data.Payroll{
 		EmployeeID: input.EmployeeID,
 		Amount:     input.Amount,
@@ -89,9 +94,9 @@ func (app *application) updatePayrollHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	var input struct {
-		EmployeeID *int64   `json:"employee_id"`
-		Amount     *float64 `json:"amount"`
-		Date       *Date    `json:"date"`
+		EmployeeID *int64      `json:"employee_id"`
+		Amount     *data.Money `json:"amount"`
+		Date       *Date       `json:"date"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	err = decoder.Decode(&input)
@@ -105,6 +110,11 @@ func (app *application) updatePayrollHandler(w http.ResponseWriter, r *http.Requ
 		payroll.EmployeeID = *input.EmployeeID
 	}
 	if input.Amount != nil {
+		v := validator.New()
+		if data.ValidateMoney(v, "amount", *input.Amount); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
 		payroll.Amount = *input.Amount
 	}
 	if input.Date != nil {
@@ -157,33 +167,57 @@ func (app *application) listPayrollsHandler(w http.ResponseWriter, r *http.Reque
 		Filters    data.Filters
 	}
 	queryString := r.URL.Query()
+	v := validator.New()
 
-	var employeeID int64
-	employeeIDFromQuery := queryString.Get("employee_id")
-	if employeeIDFromQuery != "" {
-		id, err := strconv.ParseInt(employeeIDFromQuery, 10, 64)
-		if err == nil {
-			employeeID = id
-		}
+	input.EmployeeID = int64(app.readInt(queryString, "employee_id", 0, v))
+
+	// Keyset mode, used when the client passes "after" or "limit"
+	if cursorFilters, ok := app.readCursorFilters(queryString, v); ok {
+		app.listPayrollsAfter(w, r, input.EmployeeID, cursorFilters, v)
+		return
 	}
-	input.EmployeeID = employeeID
-	v := &validator.Validator{}
+
 	input.Filters.Page = app.readInt(queryString, "page", 1, v)
 	input.Filters.PageSize = app.readInt(queryString, "page_size", 20, v)
 	input.Filters.Sort = app.readString(queryString, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "employee_id", "amount", "date",
+		"-id", "-employee_id", "-amount", "-date"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	payrolls, err := app.models.Payroll.GetAll()
+	payrolls, metadata, err := app.models.Payroll.GetAll(input.EmployeeID, input.Filters)
 	if err != nil {
-		app.errorLogger.Println("Getting payrolls", err)
-		http.Error(w, "Error when getting payrolls", http.StatusInternalServerError)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-	js, err := json.MarshalIndent(payrolls, "", "\t")
+	err = app.writeJSON(w, http.StatusOK, envelope{"payrolls": payrolls, "metadata": metadata}, nil)
 	if err != nil {
-		app.errorLogger.Println("Marshalling payroll, converting to JSON", err)
-		http.Error(w, "Error when getting payrolls", http.StatusInternalServerError)
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listPayrollsAfter sends one page of payroll entries in keyset mode, together with
+// the cursor of the next page.
+func (app *application) listPayrollsAfter(w http.ResponseWriter, r *http.Request, employeeID int64, filters data.CursorFilters, v *validator.Validator) {
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.Write(js)
+	payrolls, next, err := app.models.Payroll.GetAllAfter(employeeID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	var nextCursor *string
+	if next != nil {
+		encoded := next.Encode()
+		nextCursor = &encoded
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"payrolls": payrolls, "next_cursor": nextCursor}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"company/internal/data"
+	"company/internal/validator"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// billingReportHandler returns the billing totals of a period, grouped by customer,
+// month or currency and converted into the base currency. The period runs from the
+// "from" date to the "to" date included, by default the current year to date.
+func (app *application) billingReportHandler(w http.ResponseWriter, r *http.Request) {
+	queryString := r.URL.Query()
+	v := validator.New()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from := app.readDate(queryString, "from", time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, time.UTC), v)
+	to := app.readDate(queryString, "to", today, v)
+	groupBy := app.readString(queryString, "group_by", "customer")
+
+	v.Check(!to.Before(from), "to", "must not be before from")
+	v.Check(validator.In(groupBy, data.BillingReportGroups()...), "group_by", "invalid grouping value")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	report, err := app.models.Reports.BillingTotals(app.config.baseCurrency, groupBy, from, to.AddDate(0, 0, 1), scope)
+	if err != nil {
+		var missing *data.MissingExchangeRateError
+		switch {
+		case errors.As(err, &missing):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, missing.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Report the period as requested rather than with the exclusive end date
+	report.To = to
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// taxReportHandler returns the tax summary of a period, by default the current month
+// to date. The period runs from the "from" date to the "to" date included.
+func (app *application) taxReportHandler(w http.ResponseWriter, r *http.Request) {
+	queryString := r.URL.Query()
+	v := validator.New()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from := app.readDate(queryString, "from", time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC), v)
+	to := app.readDate(queryString, "to", today, v)
+
+	if v.Check(!to.Before(from), "to", "must not be before from"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	summary, err := app.models.Reports.TaxSummary(from, to.AddDate(0, 0, 1), scope)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	summary.To = to
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": summary}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// receivablesAgingHandler returns what each customer owes, bucketed by days past due
+// and converted into the base currency, at the end of the "as_of" date, by default
+// today.
+func (app *application) receivablesAgingHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	asOf := app.readDate(r.URL.Query(), "as_of", today, v)
+
+	if v.Check(!asOf.After(today), "as_of", "must not be in the future"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	report, err := app.models.Reports.ReceivablesAging(app.config.baseCurrency, asOf, scope)
+	if err != nil {
+		var missing *data.MissingExchangeRateError
+		switch {
+		case errors.As(err, &missing):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, missing.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"company/internal/data"
+	"company/internal/validator"
+	"errors"
+	"net/http"
+)
+
+// listPermissionsHandler returns the catalogue of permission codes which can be
+// granted to roles.
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createRoleHandler creates a custom role. It starts without permissions, they are
+// granted through grantRolePermissionsHandler.
+func (app *application) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	role := &data.Role{Name: input.Name}
+	v := validator.New()
+	if data.ValidateRole(v, role); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Roles.Insert(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRole):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// grantRolePermissionsHandler grants one or more permission codes from the catalogue
+// to the role.
+func (app *application) grantRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	role, err := app.models.Roles.GetByName(r.PathValue("name"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	catalogue, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(len(input.Permissions) > 0, "permissions", "must contain at least one permission")
+	for _, code := range input.Permissions {
+		v.Check(catalogue.Include(code), "permissions", "must only contain known permissions")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Permissions.AddForRole(role.Name, input.Permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	role, err = app.models.Roles.GetByName(role.Name)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeRolePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Permissions.RemoveForRole(r.PathValue("name"), r.PathValue("permission"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "permission successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserPermissionsHandler returns the one-off grants and denies of a user and the
+// effective permissions which result from them and the user's roles.
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserFromPath(w, r)
+	if !ok {
+		return
+	}
+	permissions, err := app.models.Permissions.GetOverridesForUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setUserPermissionHandler grants a permission to a single user, or denies it to
+// them even when one of their roles grants it.
+func (app *application) setUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserFromPath(w, r)
+	if !ok {
+		return
+	}
+	var input struct {
+		Effect string `json:"effect"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(validator.In(input.Effect, data.PermissionGrant, data.PermissionDeny), "effect", "must be grant or deny")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Permissions.SetForUser(user.ID, r.PathValue("permission"), input.Effect)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.showUserPermissionsHandler(w, r)
+}
+
+// removeUserPermissionHandler drops the grant or deny of a permission for a user.
+func (app *application) removeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserFromPath(w, r)
+	if !ok {
+		return
+	}
+	err := app.models.Permissions.RemoveForUser(user.ID, r.PathValue("permission"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.showUserPermissionsHandler(w, r)
+}
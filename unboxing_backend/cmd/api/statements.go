@@ -0,0 +1,89 @@
+package main
+
+import (
+	"company/internal/data"
+	"company/internal/validator"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// statementPage is the data of the printable customer statement.
+type statementPage struct {
+	Customer  *data.Customer
+	Statement *data.Statement
+}
+
+// showCustomerStatementHandler returns the statement of the customer in the URL path
+// for the period from the "from" date to the "to" date included, by default the
+// current year to date. With format=html it's rendered as a printable page instead
+// of JSON.
+func (app *application) showCustomerStatementHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+	queryString := r.URL.Query()
+	v := validator.New()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from := app.readDate(queryString, "from", time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, time.UTC), v)
+	to := app.readDate(queryString, "to", today, v)
+	format := app.readString(queryString, "format", "json")
+
+	v.Check(!to.Before(from), "to", "must not be before from")
+	v.Check(validator.In(format, "json", "html"), "format", "must be json or html")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	customer, err := app.models.Customers.Get(id, scope)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	statement, err := app.models.Billing.Statement(customer.ID, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	// Show the period as requested rather than with the exclusive end date
+	statement.To = to
+
+	if format == "json" {
+		err = app.writeJSON(w, http.StatusOK, envelope{"statement": statement}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	files := []string{
+		"./ui/html/base.tmpl",
+		"./ui/html/pages/customer_statement.tmpl",
+	}
+	tmpl, err := template.ParseFiles(files...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = tmpl.ExecuteTemplate(w, "base", statementPage{Customer: customer, Statement: statement})
+	if err != nil {
+		app.errorLogger.Println("Error executing template:", err.Error())
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"company/internal/data"
+	"company/internal/validator"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// readSubscriptionFromPath fetches the subscription whose ID is in the URL path,
+// sending the error response itself when it fails.
+func (app *application) readSubscriptionFromPath(w http.ResponseWriter, r *http.Request) (*data.Subscription, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	subscription, err := app.models.Subscriptions.Get(id, scope)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return subscription, true
+}
+
+func (app *application) listSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CustomerID int64
+		Filters    data.Filters
+	}
+	queryString := r.URL.Query()
+	v := validator.New()
+
+	input.CustomerID = int64(app.readInt(queryString, "customer_id", 0, v))
+	input.Filters.Page = app.readInt(queryString, "page", 1, v)
+	input.Filters.PageSize = app.readInt(queryString, "page_size", 20, v)
+	input.Filters.Sort = app.readString(queryString, "sort", "next_run_on")
+	input.Filters.SortSafelist = []string{"id", "next_run_on", "starts_on", "amount",
+		"-id", "-next_run_on", "-starts_on", "-amount"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	subscriptions, metadata, err := app.models.Subscriptions.GetAll(input.CustomerID, scope, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"subscriptions": subscriptions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createSubscriptionHandler sets up the recurring billing of a customer. The first
+// billing entry is generated on the start date, today or later and by default today,
+// and the amount is before the tax of the tax category if any. Back-dated starts are
+// refused, as they would bill every run since at once.
+func (app *application) createSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CustomerID  int64      `json:"customer_id"`
+		Description string     `json:"description"`
+		Amount      data.Money `json:"amount"`
+		TaxCategory string     `json:"tax_category"`
+		Interval    string     `json:"interval"`
+		StartsOn    *Date      `json:"starts_on"`
+		EndsOn      *Date      `json:"ends_on"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	subscription := &data.Subscription{
+		CustomerID:  input.CustomerID,
+		Description: input.Description,
+		Amount:      input.Amount,
+		TaxCategory: input.TaxCategory,
+		Interval:    input.Interval,
+		StartsOn:    time.Now().UTC().Truncate(24 * time.Hour),
+	}
+	if input.StartsOn != nil {
+		subscription.StartsOn = input.StartsOn.Time
+	}
+	if input.EndsOn != nil {
+		subscription.EndsOn = &input.EndsOn.Time
+	}
+
+	v := validator.New()
+	data.ValidateSubscription(v, subscription)
+	v.Check(!subscription.StartsOn.Before(time.Now().UTC().Truncate(24*time.Hour)), "starts_on", "must not be in the past")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if ok := app.checkCustomerInScope(w, r, subscription.CustomerID); !ok {
+		return
+	}
+
+	err = app.models.Subscriptions.Insert(subscription)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownTaxCategory):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/subscriptions/%d", subscription.ID))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"subscription": subscription}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := app.readSubscriptionFromPath(w, r)
+	if !ok {
+		return
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"subscription": subscription}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateSubscriptionHandler changes the fields sent by the client, for the billing
+// entries generated from then on. Sending a null end date makes the subscription run
+// until deleted.
+func (app *application) updateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := app.readSubscriptionFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Description *string         `json:"description"`
+		Amount      *data.Money     `json:"amount"`
+		TaxCategory *string         `json:"tax_category"`
+		EndsOn      json.RawMessage `json:"ends_on"` // Left out, null or a date
+		Version     *int32          `json:"version"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if input.Version != nil && *input.Version != subscription.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Description != nil {
+		subscription.Description = *input.Description
+	}
+	if input.Amount != nil {
+		subscription.Amount = *input.Amount
+	}
+	if input.TaxCategory != nil {
+		subscription.TaxCategory = *input.TaxCategory
+	}
+	if input.EndsOn != nil {
+		subscription.EndsOn = nil
+		if string(input.EndsOn) != "null" {
+			var endsOn Date
+			if err = json.Unmarshal(input.EndsOn, &endsOn); err != nil {
+				app.badRequestResponse(w, r, errors.New("body contains an invalid date for field \"ends_on\""))
+				return
+			}
+			subscription.EndsOn = &endsOn.Time
+		}
+	}
+
+	v := validator.New()
+	if data.ValidateSubscription(v, subscription); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Subscriptions.Update(subscription)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrUnknownTaxCategory):
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"subscription": subscription}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSubscriptionHandler stops the recurring billing, the billing entries already
+// generated being kept.
+func (app *application) deleteSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := app.readSubscriptionFromPath(w, r)
+	if !ok {
+		return
+	}
+	scope, err := app.customerScope(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Subscriptions.Delete(subscription.ID, scope)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "subscription successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runBillingScheduler bills the due subscriptions straight away, then again at every
+// interval for as long as the server runs. Every instance of the API runs it, the
+// subscriptions model making sure only one of them bills at a time.
+func (app *application) runBillingScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.billDueSubscriptions()
+		<-ticker.C
+	}
+}
+
+// billDueSubscriptions generates the billing entries of the subscriptions due today
+// or earlier, recovering from any panic so that the scheduler keeps running.
+func (app *application) billDueSubscriptions() {
+	defer func() {
+		if err := recover(); err != nil {
+			app.errorLogger.Println(fmt.Errorf("%s", err))
+		}
+	}()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	billed, err := app.models.Subscriptions.BillDue(today)
+	if err != nil {
+		app.errorLogger.Println("Billing subscriptions:", err)
+	}
+	if billed > 0 {
+		app.infoLogger.Printf("billed %d subscription runs", billed)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"company/internal/data"
+	"company/internal/validator"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// listTaxRatesHandler returns the rates of every tax category, optionally only those
+// of the category in the query string.
+func (app *application) listTaxRatesHandler(w http.ResponseWriter, r *http.Request) {
+	category := strings.ToUpper(app.readString(r.URL.Query(), "category", ""))
+
+	rates, err := app.models.Taxes.GetAll(category)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"tax_rates": rates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createTaxRateHandler adds a rate to a tax category, new or existing, from the date
+// it is effective on. Invoices saved before keep the rate they were saved with.
+func (app *application) createTaxRateHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Category    string      `json:"category"`
+		Name        string      `json:"name"`
+		Rate        json.Number `json:"rate"`
+		EffectiveOn *Date       `json:"effective_on"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rate := &data.TaxRate{
+		Category: input.Category,
+		Name:     input.Name,
+		Rate:     input.Rate.String(),
+	}
+	if input.EffectiveOn != nil {
+		rate.EffectiveOn = input.EffectiveOn.Time
+	}
+	v := validator.New()
+	if data.ValidateTaxRate(v, rate); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Taxes.Insert(rate)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"tax_rate": rate}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"company/internal/data"
+	"company/internal/validator"
 	"encoding/json"
 	"errors"
 	"log"
@@ -53,19 +54,21 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	// Generate a new token
-	token, err := app.models.Token.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if !user.Activated {
+		app.inactiveAccountResponse(w, r)
+		return
+	}
+
+	// Generate a new access token and the refresh token used to renew it
+	token, refreshToken, err := app.models.Token.NewPair(user.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL)
 	if err != nil {
 		app.errorLogger.Println("Creating new token", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	// Debugging: Log the created token
-	log.Printf("Created token: %v\n", token)
-
-	// Encode the token to JSON and send it in the response
-	response := Envelope{"authentication_token": token}
+	// Encode the tokens to JSON and send them in the response
+	response := Envelope{"authentication_token": token, "refresh_token": refreshToken}
 	responseJson, err := json.Marshal(response)
 	if err != nil {
 		app.errorLogger.Println("Error marshaling response:", err)
@@ -77,3 +80,135 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	w.WriteHeader(http.StatusCreated)
 	w.Write(responseJson)
 }
+
+// deleteAuthenticationTokenHandler logs the user out by revoking the token that was
+// used to authenticate the current request.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token := app.contextGetToken(r)
+	err := app.models.Token.Delete(data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllAuthenticationTokensHandler logs the user out of every session by revoking
+// all of their authentication tokens.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Token.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out of all sessions"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshAuthenticationTokenHandler exchanges a refresh token for a new access token
+// and a new refresh token. Presenting a refresh token which was already exchanged
+// revokes the whole session.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.RefreshToken != "", "refresh_token", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, refreshToken, err := app.models.Token.Rotate(input.RefreshToken, app.config.tokens.accessTTL, app.config.tokens.refreshTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			app.errorLogger.Println("Refresh token reused, session revoked")
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler emails a one-time password reset token to the user
+// with the given email address. The response is the same whether or not the address
+// belongs to a user, so the endpoint can't be used to find out who works here.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ttl := 45 * time.Minute
+	token, err := app.models.Token.New(user.ID, ttl, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.background(func() {
+		emailData := map[string]interface{}{
+			"name":               user.Name,
+			"passwordResetToken": token.PlainText,
+			"expiry":             ttl.String(),
+		}
+		err := app.mailer.Send(user.Email, "password_reset.tmpl", emailData)
+		if err != nil {
+			app.errorLogger.Println("Sending password reset email", err)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
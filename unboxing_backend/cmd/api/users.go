@@ -7,8 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
-	"strings"
+	"time"
 )
 
 // Enum Role
@@ -45,40 +46,142 @@ func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request)
 	w.Write(data)
 }
 
+// createUserHandler creates an account for a new employee. The account can't be used
+// until the employee follows the link in the invite email and chooses a password.
 func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Declare an anonymous struct to hold the information that we
 	// expect to be in the HTTP request body
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Role     string `json:"role"`
-		Password string `json:"password"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+		Role  string `json:"role"`
 	}
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
-		app.errorLogger.Println("Decoding request body", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	newUser := &data.User{
+		Name:      input.Name,
+		Email:     input.Email,
+		Role:      input.Role,
+		Activated: false,
+	}
+	v := validator.New()
+	if data.ValidateUser(v, newUser); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = newUser.Password.SetRandom()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 	// Feeding the data to the database
-	newUser := data.User{
-		Name: input.Name,
+	err = app.models.Users.Insert(newUser)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUnknownRole):
+			v.AddError("role", "role does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
-	if err := app.models.Users.Insert(&newUser); err != nil {
-		app.errorLogger.Println("Inserting user into database", err)
-		http.Error(w, "Database Insertion Error", http.StatusInternalServerError)
+	err = app.inviteUser(newUser)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Set("Content-Type", "application/json")
-	js, err := json.Marshal(newUser)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": newUser}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// inviteUser creates an activation token for the user and emails them the link to
+// the page where they choose their password. The email is sent in the background.
+func (app *application) inviteUser(user *data.User) error {
+	ttl := 3 * 24 * time.Hour
+	token, err := app.models.Token.New(user.ID, ttl, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+	app.background(func() {
+		emailData := map[string]interface{}{
+			"name":          user.Name,
+			"activationURL": app.config.frontendURL + "/pages/activate.html?token=" + url.QueryEscape(token.PlainText),
+			"expiry":        ttl.String(),
+		}
+		err := app.mailer.Send(user.Email, "user_invite.tmpl", emailData)
+		if err != nil {
+			app.errorLogger.Println("Sending invite email", err)
+		}
+	})
+	return nil
+}
+
+// activateUserHandler activates the account an activation token was issued to and
+// sets the password the user chose.
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
+	v.Check(input.TokenPlaintext != "", "token", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	user.Activated = true
+	err = user.Password.Set(input.Password)
 	if err != nil {
-		app.errorLogger.Println("Converting the user struct to JSON", err)
-		w.Write([]byte(`"message":"User marshalling to JSON failed"`))
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.Token.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
-	w.Write(js)
-	w.Write([]byte(`"message":"New user created"`))
 }
 
 func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -136,6 +239,10 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 			app.errorLogger.Println("Edit conflict", err)
 			http.Error(w, "Unable to update the record due to edit conflict, please try again", http.StatusConflict)
 			return
+		case errors.Is(err, data.ErrUnknownRole):
+			app.errorLogger.Println("Unknown role", err)
+			http.Error(w, "Role does not exist", http.StatusUnprocessableEntity)
+			return
 		default:
 			app.errorLogger.Println("Updating user ID=", user.ID, err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -178,50 +285,211 @@ func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request)
 	}
 	// Parse and get the params from the query string
 	queryString := r.URL.Query()
+	v := validator.New()
 
-	var name string
-	defaultName := ""
-	// Get the name from query string
-	nameFromQuery := queryString.Get("name")
-	if nameFromQuery == "" {
-		name = defaultName
-	} else {
-		name = nameFromQuery
-	}
-	input.Name = name
-
-	// Get the roles from the query string
-	var roles []string
-	defaultRoles := []string{}
-	rolesFromQuery := queryString.Get("roles")
-	if rolesFromQuery == "" {
-		roles = defaultRoles
-	} else {
-		roles = strings.Split(rolesFromQuery, ",")
-	}
-	input.Roles = roles
-	v := &validator.Validator{}
+	input.Name = app.readString(queryString, "name", "")
+	input.Roles = app.readCSV(queryString, "roles", []string{})
 	// Get the page number
 	input.Filters.Page = app.readInt(queryString, "page", 1, v)
 	input.Filters.PageSize = app.readInt(queryString, "page_size", 20, v)
 
 	// Extract the sort query string value, falling back to "id" if it is not provided
 	input.Filters.Sort = app.readString(queryString, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "role", "created_at",
+		"-id", "-name", "-email", "-role", "-created_at"}
 
-	users, err := app.models.Users.GetAll()
-	if err != nil {
-		app.errorLogger.Println("Getting users", err)
-		http.Error(w, "Error when getting users", http.StatusInternalServerError)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	js, err := json.MarshalIndent(users, "", "\t")
+
+	users, metadata, err := app.models.Users.GetAll(input.Name, input.Roles, input.Filters)
 	if err != nil {
-		app.errorLogger.Println("Marshalling user, converting to JSON", err)
-		http.Error(w, "Error when getting users", http.StatusInternalServerError)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.Write(js)
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 //GetForToken() returns the user, associated with a token
+
+// updateUserPasswordHandler sets a new password for the user a password reset token
+// was issued to. The token can only be used once, and since the old password may be
+// compromised every session of the user is revoked as well.
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
+	v.Check(input.TokenPlaintext != "", "token", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	for _, scope := range []string{data.ScopePasswordReset, data.ScopeAuthentication, data.ScopeRefresh} {
+		err = app.models.Token.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// assignUserRoleHandler gives the user an additional role on top of their primary
+// role. The user ends up with the permissions of all of their roles.
+func (app *application) assignUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(input.Role != "", "role", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	role, err := app.models.Roles.GetByName(input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("role", "role does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.Users.AddRole(user.ID, role.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user, err = app.models.Users.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unassignUserRoleHandler takes an additional role away from the user. The primary
+// role can't be removed this way, it is changed by updating the user instead.
+func (app *application) unassignUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserFromPath(w, r)
+	if !ok {
+		return
+	}
+	roleName := r.PathValue("role")
+	if roleName == user.Role {
+		v := validator.New()
+		v.AddError("role", "the primary role can't be unassigned, update the user's role instead")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	role, err := app.models.Roles.GetByName(roleName)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.models.Users.RemoveRole(user.ID, role.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err = app.models.Users.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserFromPath looks up the user identified by the {id} path value. When that
+// fails it sends the error response itself and returns false.
+func (app *application) readUserFromPath(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return user, true
+}
@@ -1,9 +1,11 @@
 package data
 
 import (
+	"company/internal/validator"
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
@@ -11,95 +13,268 @@ import (
 )
 
 type Billing struct {
-	ID         int64     `json:"id"`         // Unique integer ID for each billing entry
-	CustomerID int64     `json:"customer_id"`// Customer ID to whom the billing belongs
-	Amount     float64   `json:"amount"`     // Billing amount
-	Date       time.Time `json:"date"`       // Billing date
-	Version    int32     `json:"version"`    // Version number for optimistic locking
+	ID             int64        `json:"id"`                     // Unique integer ID for each billing entry
+	CustomerID     int64        `json:"customer_id"`            // Customer ID to whom the billing belongs
+	Amount         Money        `json:"amount"`                 // Billing amount and currency, tax included
+	Date           time.Time    `json:"date"`                   // Billing date
+	Version        int32        `json:"version"`                // Version number for optimistic locking
+	Status         string       `json:"status"`                 // Status of the invoice behind the entry
+	AmountPaid     Money        `json:"amount_paid"`            // Payments received so far, net of refunds
+	AmountCredited Money        `json:"amount_credited"`        // Taken off by credit notes
+	Number         string       `json:"number"`                 // Invoice number, empty for drafts
+	Subtotal       Money        `json:"subtotal"`               // Amount before tax
+	TaxTotal       Money        `json:"tax_total"`              // Tax charged
+	TaxTreatment   string       `json:"tax_treatment"`          // Tax treatment of the customer when billed
+	TaxCategory    string       `json:"tax_category,omitempty"` // Tax category of a new entry
+	Taxes          []*TaxAmount `json:"taxes,omitempty"`        // Tax breakdown, of a single entry only
 }
 
 type BillingModel struct {
-	DB *sql.DB
+	DB        *sql.DB
+	Numbering Numbering
 }
 
-// GetAll fetches all billing entries from the database.
-func (m BillingModel) GetAll() ([]*Billing, error) {
-	query := `
-	SELECT id, customer_id, amount, date, version
+// invoices returns the model of the invoices behind the billing entries.
+func (m BillingModel) invoices() InvoiceModel {
+	return InvoiceModel{DB: m.DB, Numbering: m.Numbering}
+}
+
+// GetAll fetches a page of the billing entries of the customers visible in the scope
+// from the database, optionally only those of a single customer (customerID 0 means
+// every customer).
+func (m BillingModel) GetAll(customerID int64, scope CustomerScope, filters Filters) ([]*Billing, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), billing.id, billing.customer_id, billing.amount, billing.currency, billing.date, billing.version, billing.status, billing.amount_paid, coalesce(billing.number, ''),
+		billing.subtotal, billing.tax_total, billing.tax_treatment, billing.amount_credited
 	FROM billing
-	ORDER BY id
-	`
+	INNER JOIN customers ON customers.id = billing.customer_id
+	WHERE ($1::bigint = 0 OR customers.account_manager_id = $1)
+	AND ($2::bigint = 0 OR billing.customer_id = $2)
+	ORDER BY billing.%s %s, billing.id ASC
+	LIMIT $3 OFFSET $4
+	`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	args := []interface{}{scope.AccountManagerID, customerID, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		log.Println("Error getting billing entries", err)
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := 0
 	billings := []*Billing{}
 
 	for rows.Next() {
 		var billing Billing
 
 		err = rows.Scan(
+			&totalRecords,
 			&billing.ID,
 			&billing.CustomerID,
 			&billing.Amount,
+			&billing.Amount.Currency,
 			&billing.Date,
 			&billing.Version,
+			&billing.Status,
+			&billing.AmountPaid,
+			&billing.Number,
+			&billing.Subtotal,
+			&billing.TaxTotal,
+			&billing.TaxTreatment,
+			&billing.AmountCredited,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
+		billing.setCurrencies()
 		billings = append(billings, &billing)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
-	return billings, nil
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return billings, metadata, nil
 }
 
-// Insert adds a new billing entry to the database.
-func (m BillingModel) Insert(billing *Billing) error {
+// GetAllAfter fetches the billing entries following the cursor position in (date, id)
+// order, for ledgers too long to page through with offsets. The returned cursor
+// points at the last entry and is nil when there are no more entries.
+func (m BillingModel) GetAllAfter(customerID int64, scope CustomerScope, filters CursorFilters) ([]*Billing, *Cursor, error) {
 	query := `
-	INSERT INTO billing (customer_id, amount, date)
-	VALUES ($1, $2, $3)
-	RETURNING id, version
+	SELECT billing.id, billing.customer_id, billing.amount, billing.currency, billing.date, billing.version, billing.status, billing.amount_paid, coalesce(billing.number, ''),
+		billing.subtotal, billing.tax_total, billing.tax_treatment, billing.amount_credited
+	FROM billing
+	INNER JOIN customers ON customers.id = billing.customer_id
+	WHERE ($1::bigint = 0 OR customers.account_manager_id = $1)
+	AND ($2::bigint = 0 OR billing.customer_id = $2)
+	AND (billing.date, billing.id) > ($3, $4)
+	ORDER BY billing.date ASC, billing.id ASC
+	LIMIT $5
 	`
 
-	err := m.DB.QueryRow(query, billing.CustomerID, billing.Amount, billing.Date).Scan(&billing.ID, &billing.Version)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	afterDate, afterID := filters.args()
+	// Ask for one entry more than the limit to find out whether there is a next page.
+	args := []interface{}{scope.AccountManagerID, customerID, afterDate, afterID, filters.Limit + 1}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Println("Creating billing entry in the database", err)
-	} else {
-		log.Printf("Billing entry with ID: %d created successfully in the database\n", billing.ID)
+		log.Println("Error getting billing entries", err)
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	billings := []*Billing{}
+
+	for rows.Next() {
+		var billing Billing
+
+		err = rows.Scan(
+			&billing.ID,
+			&billing.CustomerID,
+			&billing.Amount,
+			&billing.Amount.Currency,
+			&billing.Date,
+			&billing.Version,
+			&billing.Status,
+			&billing.AmountPaid,
+			&billing.Number,
+			&billing.Subtotal,
+			&billing.TaxTotal,
+			&billing.TaxTreatment,
+			&billing.AmountCredited,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		billing.setCurrencies()
+		billings = append(billings, &billing)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var next *Cursor
+	if len(billings) > filters.Limit {
+		billings = billings[:filters.Limit]
+		last := billings[len(billings)-1]
+		next = &Cursor{Date: last.Date, ID: last.ID}
 	}
-	return err
+	return billings, next, nil
 }
 
-// Get fetches a specific billing entry from the database by ID.
-func (m BillingModel) Get(id int64) (*Billing, error) {
+// ValidationError is returned when what is saved fails validation, with the errors
+// by field as a validator would report them.
+type ValidationError struct {
+	Errors map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("validation failed: %v", e.Errors)
+}
+
+// billingInvoiceFields maps the invoice fields a billing entry is saved into back to
+// the fields of the entry.
+var billingInvoiceFields = map[string]string{
+	"customer_id":           "customer_id",
+	"currency":              "amount",
+	"issue_date":            "date",
+	"due_date":              "date",
+	"lines[0].unit_price":   "amount",
+	"lines[0].tax_category": "tax_category",
+}
+
+// Insert adds a new billing entry to the database, as a draft invoice with a single
+// line billing the subtotal, plus the tax of the tax category of the entry if any.
+// Like any draft it can be changed or deleted until it is issued, which numbers it
+// and dates it the day it is issued. The invoice is validated like any other,
+// failing with a ValidationError about the fields of the entry.
+func (m BillingModel) Insert(billing *Billing) error {
+	invoice := &Invoice{
+		Series:     m.Numbering.DefaultInvoiceSeries(),
+		CustomerID: billing.CustomerID,
+		Currency:   billing.Amount.Currency,
+		IssueDate:  billing.Date,
+		DueDate:    billing.Date.AddDate(0, 0, DefaultPaymentTerms),
+		Status:     InvoiceDraft,
+		Lines:      []*InvoiceLine{billingLine(billing.Subtotal, billing.TaxCategory)},
+	}
+	v := validator.New()
+	if ValidateInvoice(v, invoice, m.Numbering); !v.Valid() {
+		errs := make(map[string]string, len(v.Errors))
+		for key, message := range v.Errors {
+			if field, ok := billingInvoiceFields[key]; ok {
+				key = field
+			}
+			if _, exists := errs[key]; !exists {
+				errs[key] = message
+			}
+		}
+		return &ValidationError{Errors: errs}
+	}
+	if err := invoice.CalculateTotals(); err != nil {
+		return err
+	}
+	err := m.invoices().Insert(invoice)
+	if err != nil {
+		return err
+	}
+	billing.ID = invoice.ID
+	billing.Amount = invoice.Total
+	billing.TaxTotal = invoice.TaxTotal
+	billing.TaxTreatment = invoice.TaxTreatment
+	billing.Taxes = invoice.Taxes
+	billing.Version = invoice.Version
+	billing.Status = invoice.Status
+	billing.AmountPaid = invoice.AmountPaid
+	billing.AmountCredited = invoice.AmountCredited
+	billing.Number = invoice.Number
+	log.Printf("Billing entry with ID: %d created successfully in the database\n", billing.ID)
+	return nil
+}
+
+// billingLine is the invoice line of a billing entry created without details.
+func billingLine(amount Money, taxCategory string) *InvoiceLine {
+	return &InvoiceLine{Description: "Billing", Quantity: "1", UnitPrice: amount, TaxCategory: taxCategory, TaxRate: "0"}
+}
+
+// Get fetches a specific billing entry from the database by ID. Entries of customers
+// outside of the scope are reported as not found.
+func (m BillingModel) Get(id int64, scope CustomerScope) (*Billing, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
 	query := `
-	SELECT id, customer_id, amount, date, version 
+	SELECT billing.id, billing.customer_id, billing.amount, billing.currency, billing.date, billing.version, billing.status, billing.amount_paid, coalesce(billing.number, ''),
+		billing.subtotal, billing.tax_total, billing.tax_treatment, billing.amount_credited
 	FROM billing
-	WHERE id = $1
+	INNER JOIN customers ON customers.id = billing.customer_id
+	WHERE billing.id = $1 AND ($2::bigint = 0 OR customers.account_manager_id = $2)
 	`
 
 	var billing Billing
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, query, id, scope.AccountManagerID).Scan(
 		&billing.ID,
 		&billing.CustomerID,
 		&billing.Amount,
+		&billing.Amount.Currency,
 		&billing.Date,
 		&billing.Version,
+		&billing.Status,
+		&billing.AmountPaid,
+		&billing.Number,
+		&billing.Subtotal,
+		&billing.TaxTotal,
+		&billing.TaxTreatment,
+		&billing.AmountCredited,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -110,56 +285,78 @@ func (m BillingModel) Get(id int64) (*Billing, error) {
 			return nil, err
 		}
 	}
+	billing.setCurrencies()
+
+	// The tax breakdown is worked out from the lines of the invoice
+	invoice := &Invoice{ID: billing.ID, Currency: billing.Amount.Currency, TaxTreatment: billing.TaxTreatment}
+	if err = m.invoices().getLines(ctx, invoice); err != nil {
+		return nil, err
+	}
+	billing.Taxes = invoice.Taxes
 	return &billing, nil
 }
 
-// Update modifies an existing billing entry in the database.
+// setCurrencies gives the amounts scanned from the database the currency of the entry.
+func (b *Billing) setCurrencies() {
+	b.AmountPaid.Currency = b.Amount.Currency
+	b.Subtotal.Currency = b.Amount.Currency
+	b.TaxTotal.Currency = b.Amount.Currency
+	b.AmountCredited.Currency = b.Amount.Currency
+}
+
+// Update modifies the invoice behind an existing billing entry, as long as it is a
+// draft. Its subtotal can only be changed when the invoice has a single line, which
+// then bills the new subtotal in the same tax category.
 func (m BillingModel) Update(billing *Billing) error {
-	query := `
-	UPDATE billing
-	SET customer_id = $1, amount = $2, date = $3, version = version + 1
-	WHERE id = $4 AND version = $5
-	RETURNING version
-	`
+	invoices := m.invoices()
+	invoice, err := invoices.Get(billing.ID, CustomerScope{})
+	if err != nil {
+		return err
+	}
+	if !invoice.Editable() {
+		return ErrInvoiceNotEditable
+	}
 
-	err := m.DB.QueryRow(query, billing.CustomerID, billing.Amount, billing.Date, billing.ID, billing.Version).Scan(&billing.Version)
+	if invoice.Subtotal != billing.Subtotal {
+		if len(invoice.Lines) != 1 {
+			return ErrInvoiceHasLines
+		}
+		invoice.Currency = billing.Subtotal.Currency
+		invoice.Lines[0] = billingLine(billing.Subtotal, invoice.Lines[0].TaxCategory)
+	}
+	if !billing.Date.Equal(invoice.IssueDate) {
+		invoice.DueDate = invoice.DueDate.Add(billing.Date.Sub(invoice.IssueDate))
+	}
+	invoice.CustomerID = billing.CustomerID
+	invoice.IssueDate = billing.Date
+	invoice.Version = billing.Version
+	if err = invoice.CalculateTotals(); err != nil {
+		return err
+	}
+
+	err = invoices.Update(invoice)
 	if err != nil {
 		switch {
-		case errors.Is(err, sql.ErrNoRows):
+		case errors.Is(err, ErrEditConflict):
 			log.Println("Edit conflict (version)", err)
 			return ErrEditConflict
 		default:
 			log.Println("Updating billing entry", err)
 			return err
 		}
-	} else {
-		log.Println("Billing entry updated successfully")
 	}
+	billing.Amount = invoice.Total
+	billing.TaxTotal = invoice.TaxTotal
+	billing.TaxTreatment = invoice.TaxTreatment
+	billing.Taxes = invoice.Taxes
+	billing.Version = invoice.Version
+	log.Println("Billing entry updated successfully")
 	return nil
 }
 
-// Delete removes a billing entry from the database.
-func (m BillingModel) Delete(id int64) error {
-	query := `
-	DELETE FROM billing
-	WHERE id = $1
-	`
-
-	results, err := m.DB.Exec(query, id)
-	if err != nil {
-		log.Println("Delete operation", err)
-		return err
-	}
-
-	rowsAffected, err := results.RowsAffected()
-	if err != nil {
-		log.Println("Error checking rows affected", err)
-		return err
-	}
-
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
-
-	return nil
+// Delete removes a billing entry of a customer within the scope from the database,
+// together with the lines of its invoice. Only drafts can be deleted, issued invoices
+// have to be voided.
+func (m BillingModel) Delete(id int64, scope CustomerScope) error {
+	return m.invoices().Delete(id, scope)
 }
@@ -0,0 +1,275 @@
+package data
+
+import (
+	"company/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+)
+
+// CreditNote cancels all or part of an issued invoice, which keeps its history
+// instead of being deleted. The amount first comes off what is left to pay on the
+// invoice, the rest being refunded to the customer out of what they paid. Taxes is
+// what the credit note takes off the tax breakdown of the invoice.
+type CreditNote struct {
+	ID        int64        `json:"id"`
+	InvoiceID int64        `json:"invoice_id"`
+	Number    string       `json:"number"`
+	Amount    Money        `json:"amount"` // Tax included
+	Refund    Money        `json:"refund"` // Part of the amount paid back to the customer
+	IssuedOn  time.Time    `json:"issued_on"`
+	Reason    string       `json:"reason"`
+	Taxes     []*TaxAmount `json:"taxes"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// creditedTaxes splits an amount credited on the invoice into taxable amounts and tax
+// in proportion to the tax breakdown of the invoice. The rounding difference goes to
+// the taxable amount of the last entry, so that the taxable amounts and the tax
+// charged add up to the amount credited.
+func creditedTaxes(invoice *Invoice, credited int64) ([]*TaxAmount, error) {
+	if invoice.Total.Amount <= 0 || len(invoice.Taxes) == 0 {
+		return nil, fmt.Errorf("invoice %d has no tax breakdown to credit", invoice.ID)
+	}
+	ratio := big.NewRat(credited, invoice.Total.Amount)
+	taxes := make([]*TaxAmount, len(invoice.Taxes))
+	charged := Money{Currency: invoice.Currency}
+
+	for n, t := range invoice.Taxes {
+		taxable, err := t.Taxable.MulRat(ratio)
+		if err != nil {
+			return nil, err
+		}
+		tax, err := t.Tax.MulRat(ratio)
+		if err != nil {
+			return nil, err
+		}
+		taxes[n] = &TaxAmount{Category: t.Category, Rate: t.Rate, Taxable: taxable, Tax: tax, ReverseCharge: t.ReverseCharge}
+
+		if charged, err = charged.Add(taxable); err != nil {
+			return nil, err
+		}
+		if !t.ReverseCharge {
+			if charged, err = charged.Add(tax); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	last := taxes[len(taxes)-1]
+	last.Taxable.Amount += credited - charged.Amount
+	return taxes, nil
+}
+
+// creditNoteTaxes works out what the credit note takes off the tax breakdown of the
+// invoice, as the difference between what is credited with and without it. Crediting
+// the whole invoice, in one credit note or several, so takes off its exact breakdown.
+func creditNoteTaxes(note *CreditNote, invoice *Invoice) ([]*TaxAmount, error) {
+	before, err := creditedTaxes(invoice, invoice.AmountCredited.Amount)
+	if err != nil {
+		return nil, err
+	}
+	after, err := creditedTaxes(invoice, invoice.AmountCredited.Amount+note.Amount.Amount)
+	if err != nil {
+		return nil, err
+	}
+	for n, t := range after {
+		t.Taxable.Amount -= before[n].Taxable.Amount
+		t.Tax.Amount -= before[n].Tax.Amount
+	}
+	return after, nil
+}
+
+// ValidateCreditNote checks the credit note can be issued against the invoice, which
+// it must not credit more than its total. Its date is set when it is issued.
+func ValidateCreditNote(v *validator.Validator, note *CreditNote, invoice *Invoice) {
+	ValidateMoney(v, "amount", note.Amount)
+	v.Check(note.Amount.Currency == invoice.Currency, "amount", "must be in the currency of the invoice")
+	v.Check(note.Amount.Amount <= invoice.Total.Amount-invoice.AmountCredited.Amount, "amount", "must not be more than the total of the invoice not credited yet")
+	v.Check(note.Reason != "", "reason", "must be provided")
+	v.Check(len(note.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+type CreditNoteModel struct {
+	DB        *sql.DB
+	Numbering Numbering
+}
+
+// Insert issues the credit note with the next number of the credit note series and
+// credits the invoice, which becomes paid once nothing is left to pay, unless the
+// invoice was changed since it was read. The credit note is dated the day it is
+// issued, so that numbers follow the order of issue dates and credit notes never
+// change the tax of a past period. The invoice must have been read with its lines,
+// which its tax breakdown comes from.
+func (m CreditNoteModel) Insert(note *CreditNote, invoice *Invoice) error {
+	taxes, err := creditNoteTaxes(note, invoice)
+	if err != nil {
+		return err
+	}
+	note.Taxes = taxes
+	note.IssuedOn = time.Now().UTC().Truncate(24 * time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	note.Refund = Money{Currency: note.Amount.Currency}
+	if note.Amount.Amount > invoice.Balance.Amount {
+		note.Refund.Amount = note.Amount.Amount - invoice.Balance.Amount
+	}
+
+	query := `
+	UPDATE invoices
+	SET amount_credited = amount_credited + $1,
+		amount_paid = amount_paid - $2,
+		status = CASE WHEN amount_paid + amount_credited + $1 >= total THEN 'paid' ELSE status END,
+		version = version + 1
+	WHERE id = $3 AND version = $4
+	AND status IN ('issued', 'partially_paid', 'paid') AND amount_credited + $1 <= total
+	RETURNING invoice_status(status, due_date), amount_paid, amount_credited, version
+	`
+	args := []interface{}{note.Amount, note.Refund, invoice.ID, invoice.Version}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&invoice.Status, &invoice.AmountPaid, &invoice.AmountCredited, &invoice.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			log.Println("Crediting invoice", err)
+			return err
+		}
+	}
+	invoice.setBalance()
+
+	series := m.Numbering.DefaultCreditNoteSeries()
+	note.Number, err = m.Numbering.Next(ctx, tx, series, note.IssuedOn)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	INSERT INTO credit_notes (invoice_id, series, number, amount, refund, currency, issued_on, reason)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id, created_at
+	`
+	note.InvoiceID = invoice.ID
+	args = []interface{}{note.InvoiceID, series, note.Number, note.Amount, note.Refund, note.Amount.Currency, note.IssuedOn, note.Reason}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&note.ID, &note.CreatedAt)
+	if err != nil {
+		log.Println("Creating credit note in the database", err)
+		return err
+	}
+
+	query = `
+	INSERT INTO credit_note_taxes (credit_note_id, category, rate, taxable, tax, reverse_charge)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	for _, t := range note.Taxes {
+		_, err = tx.ExecContext(ctx, query, note.ID, t.Category, t.Rate, t.Taxable, t.Tax, t.ReverseCharge)
+		if err != nil {
+			log.Println("Creating credit note taxes in the database", err)
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Credit note %s of %s issued on invoice %s\n", note.Number, note.Amount, invoice.Number)
+	return nil
+}
+
+// GetAllForInvoice fetches the credit notes of an invoice, oldest first.
+func (m CreditNoteModel) GetAllForInvoice(invoiceID int64) ([]*CreditNote, error) {
+	query := `
+	SELECT id, invoice_id, number, amount, refund, currency, issued_on, reason, created_at
+	FROM credit_notes
+	WHERE invoice_id = $1
+	ORDER BY issued_on, id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, invoiceID)
+	if err != nil {
+		log.Println("Error getting credit notes", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []*CreditNote{}
+	for rows.Next() {
+		var note CreditNote
+
+		err = rows.Scan(
+			&note.ID,
+			&note.InvoiceID,
+			&note.Number,
+			&note.Amount,
+			&note.Refund,
+			&note.Amount.Currency,
+			&note.IssuedOn,
+			&note.Reason,
+			&note.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		note.Refund.Currency = note.Amount.Currency
+		note.Taxes = []*TaxAmount{}
+		notes = append(notes, &note)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+	SELECT credit_note_taxes.credit_note_id, credit_note_taxes.category, credit_note_taxes.rate::text,
+		credit_note_taxes.taxable, credit_note_taxes.tax, credit_note_taxes.reverse_charge
+	FROM credit_note_taxes
+	INNER JOIN credit_notes ON credit_notes.id = credit_note_taxes.credit_note_id
+	WHERE credit_notes.invoice_id = $1
+	ORDER BY credit_note_taxes.credit_note_id, credit_note_taxes.category, credit_note_taxes.rate
+	`
+	taxRows, err := m.DB.QueryContext(ctx, query, invoiceID)
+	if err != nil {
+		log.Println("Error getting credit note taxes", err)
+		return nil, err
+	}
+	defer taxRows.Close()
+
+	byID := make(map[int64]*CreditNote, len(notes))
+	for _, note := range notes {
+		byID[note.ID] = note
+	}
+	for taxRows.Next() {
+		var (
+			id  int64
+			tax TaxAmount
+		)
+		err = taxRows.Scan(&id, &tax.Category, &tax.Rate, &tax.Taxable, &tax.Tax, &tax.ReverseCharge)
+		if err != nil {
+			return nil, err
+		}
+		note := byID[id]
+		tax.Rate = trimDecimal(tax.Rate)
+		tax.Taxable.Currency = note.Amount.Currency
+		tax.Tax.Currency = note.Amount.Currency
+		note.Taxes = append(note.Taxes, &tax)
+	}
+
+	if err = taxRows.Err(); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"company/internal/validator"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Cursor marks a position in a ledger (billing or payroll) ordered by (date, id). It
+// is handed to clients as an opaque string, so its layout can change without
+// breaking them.
+type Cursor struct {
+	Date time.Time `json:"d"`
+	ID   int64     `json:"i"`
+}
+
+// Encode returns the opaque string form of the cursor.
+func (c Cursor) Encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// DecodeCursor parses a string returned by Cursor.Encode.
+func DecodeCursor(s string) (*Cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// CursorFilters holds the parameters of a keyset paginated query. After is nil for
+// the first page.
+type CursorFilters struct {
+	After *Cursor
+	Limit int
+}
+
+func ValidateCursorFilters(v *validator.Validator, f CursorFilters) {
+	v.Check(f.Limit > 0, "limit", "must be greater than zero")
+	v.Check(f.Limit <= 100, "limit", "must be a maximum of 100")
+}
+
+// args returns the query arguments for the "after" position. Without a cursor the
+// position is before every row.
+func (f CursorFilters) args() (time.Time, int64) {
+	if f.After == nil {
+		return time.Time{}, 0
+	}
+	return f.After.Date, f.After.ID
+}
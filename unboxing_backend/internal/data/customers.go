@@ -4,80 +4,204 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"html"
 	"log"
+	"strings"
 	"time"
+	"unicode"
 
 	_ "github.com/lib/pq"
 )
 
 type Customer struct {
-	ID        int64     `json:"id"`       // Unique integer ID for each customer
-	CreatedAt time.Time `json:"-"`        // Timestamp created for customer automatically when added to the database
-	Name      string    `json:"name"`     // Customer's name
-	Email     string    `json:"email"`    // Customer's email address
-	Phone     string    `json:"phone"`    // Customer's phone number
-	Address   string    `json:"address"`  // Customer's address
-	Version   int32     `json:"version"`  // Version number for optimistic locking
+	ID               int64     `json:"id"`                 // Unique integer ID for each customer
+	CreatedAt        time.Time `json:"-"`                  // Timestamp created for customer automatically when added to the database
+	Name             string    `json:"name"`               // Customer's name
+	Email            string    `json:"email"`              // Customer's email address
+	Phone            string    `json:"phone"`              // Customer's phone number
+	Address          string    `json:"address"`            // Customer's address
+	AccountManagerID *int64    `json:"account_manager_id"` // Sales user managing the account, if any
+	TaxTreatment     string    `json:"tax_treatment"`      // How the customer is taxed, see TaxTreatments
+	TaxID            string    `json:"tax_id"`             // Tax registration number, such as a GSTIN or VAT number
+	Version          int32     `json:"version"`            // Version number for optimistic locking
+}
+
+// CustomerScope restricts which customers a query can see. The zero value sees every
+// customer; with AccountManagerID set only the customers managed by that user are
+// visible, as well as the billing entries of those customers.
+type CustomerScope struct {
+	AccountManagerID int64
+}
+
+// CustomerSearchResult is a customer matching a search, with its relevance and the
+// matching parts of its details highlighted with <mark> tags. The rest of the snippet
+// is HTML-escaped, so that it can be rendered as is.
+type CustomerSearchResult struct {
+	*Customer
+	Rank    float32 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// SearchQuery turns free text typed by a user into a tsquery which matches customers
+// having every word as a prefix of one of their terms. Besides the whole email, its
+// local part, its domain and the words within them are terms, as are the digits of
+// the phone number, so that "jo gma" finds "John <john@gmail.com>". Anything but
+// letters, digits and the characters found in emails and phone numbers is dropped, so
+// the result is always valid tsquery syntax. It returns an empty string when nothing
+// searchable is left.
+func SearchQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("@.-_+", r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, "'"+strings.ToLower(word)+"':*")
+	}
+	return strings.Join(terms, " & ")
+}
+
+// highlight HTML-escapes a headline whose matches are delimited by the STX and ETX
+// control characters, then marks the matches with <mark> tags. Delimiting matches with
+// the tags themselves would not tell them apart from markup in the details, whereas a
+// stray control character in the details at worst gives an unbalanced <mark>.
+func highlight(headline string) string {
+	return strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>").Replace(html.EscapeString(headline))
 }
 
 type CustomerModel struct {
 	DB *sql.DB
 }
 
-// GetAll fetches all customers from the database.
-func (m CustomerModel) GetAll() ([]*Customer, error) {
-	query := `
-	SELECT id, created_at, name, email, phone, address, version
+// GetAll fetches a page of the customers visible in the scope from the database,
+// optionally only those whose name contains the name filter.
+func (m CustomerModel) GetAll(name string, scope CustomerScope, filters Filters) ([]*Customer, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, email, phone, address, account_manager_id, tax_treatment, tax_id, version
 	FROM customers
-	ORDER BY id
-	`
+	WHERE ($1::bigint = 0 OR account_manager_id = $1)
+	AND (name ILIKE '%%' || $2 || '%%' OR $2 = '')
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4
+	`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	args := []interface{}{scope.AccountManagerID, name, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		log.Println("Error getting customers", err)
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := 0
 	customers := []*Customer{}
 
 	for rows.Next() {
 		var customer Customer
 
 		err = rows.Scan(
+			&totalRecords,
 			&customer.ID,
 			&customer.CreatedAt,
 			&customer.Name,
 			&customer.Email,
 			&customer.Phone,
 			&customer.Address,
+			&customer.AccountManagerID,
+			&customer.TaxTreatment,
+			&customer.TaxID,
 			&customer.Version,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 		customers = append(customers, &customer)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return customers, metadata, nil
+}
+
+// Search fetches a page of the customers visible in the scope which match the search
+// query, built by SearchQuery. Besides the usual sort columns results can be sorted
+// by "rank", their relevance to the query.
+func (m CustomerModel) Search(searchQuery string, scope CustomerScope, filters Filters) ([]*CustomerSearchResult, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, email, phone, address, account_manager_id, tax_treatment, tax_id, version,
+		ts_rank(search, query) AS rank,
+		ts_headline('simple', concat_ws(' | ', name, email, phone, address), query,
+			'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MaxFragments=2')
+	FROM customers, to_tsquery('simple', $2) query
+	WHERE ($1::bigint = 0 OR account_manager_id = $1)
+	AND search @@ query
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	args := []interface{}{scope.AccountManagerID, searchQuery, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println("Error searching customers", err)
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	results := []*CustomerSearchResult{}
+
+	for rows.Next() {
+		result := CustomerSearchResult{Customer: &Customer{}}
+
+		err = rows.Scan(
+			&totalRecords,
+			&result.ID,
+			&result.CreatedAt,
+			&result.Name,
+			&result.Email,
+			&result.Phone,
+			&result.Address,
+			&result.AccountManagerID,
+			&result.TaxTreatment,
+			&result.TaxID,
+			&result.Version,
+			&result.Rank,
+			&result.Snippet,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		result.Snippet = highlight(result.Snippet)
+		results = append(results, &result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
 	}
 
-	return customers, nil
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return results, metadata, nil
 }
 
 // Insert adds a new customer to the database.
 func (m CustomerModel) Insert(customer *Customer) error {
 	query := `
-	INSERT INTO customers (name, email, phone, address)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO customers (name, email, phone, address, account_manager_id, tax_treatment, tax_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
 	RETURNING id, created_at, version
 	`
 
-	err := m.DB.QueryRow(query, customer.Name, customer.Email, customer.Phone, customer.Address).Scan(&customer.ID, &customer.CreatedAt, &customer.Version)
+	args := []interface{}{customer.Name, customer.Email, customer.Phone, customer.Address, customer.AccountManagerID, customer.TaxTreatment, customer.TaxID}
+	err := m.DB.QueryRow(query, args...).Scan(&customer.ID, &customer.CreatedAt, &customer.Version)
 	if err != nil {
 		log.Println("Creating customer in the database", err)
 	} else {
@@ -86,25 +210,29 @@ func (m CustomerModel) Insert(customer *Customer) error {
 	return err
 }
 
-// Get fetches a specific customer from the database by ID.
-func (m CustomerModel) Get(id int64) (*Customer, error) {
+// Get fetches a specific customer from the database by ID. Customers outside of the
+// scope are reported as not found.
+func (m CustomerModel) Get(id int64, scope CustomerScope) (*Customer, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
 	query := `
-	SELECT id, created_at, name, email, phone, address, version 
+	SELECT id, created_at, name, email, phone, address, account_manager_id, tax_treatment, tax_id, version
 	FROM customers
-	WHERE id = $1
+	WHERE id = $1 AND ($2::bigint = 0 OR account_manager_id = $2)
 	`
 
 	var customer Customer
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, query, id, scope.AccountManagerID).Scan(
 		&customer.ID,
 		&customer.CreatedAt,
 		&customer.Name,
 		&customer.Email,
 		&customer.Phone,
 		&customer.Address,
+		&customer.AccountManagerID,
+		&customer.TaxTreatment,
+		&customer.TaxID,
 		&customer.Version,
 	)
 	if err != nil {
@@ -123,12 +251,14 @@ func (m CustomerModel) Get(id int64) (*Customer, error) {
 func (m CustomerModel) Update(customer *Customer) error {
 	query := `
 	UPDATE customers
-	SET name = $1, email = $2, phone = $3, address = $4, version = version + 1
-	WHERE id = $5 AND version = $6
+	SET name = $1, email = $2, phone = $3, address = $4, account_manager_id = $5, tax_treatment = $6, tax_id = $7,
+		version = version + 1
+	WHERE id = $8 AND version = $9
 	RETURNING version
 	`
 
-	err := m.DB.QueryRow(query, customer.Name, customer.Email, customer.Phone, customer.Address, customer.ID, customer.Version).Scan(&customer.Version)
+	args := []interface{}{customer.Name, customer.Email, customer.Phone, customer.Address, customer.AccountManagerID, customer.TaxTreatment, customer.TaxID, customer.ID, customer.Version}
+	err := m.DB.QueryRow(query, args...).Scan(&customer.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -144,14 +274,34 @@ func (m CustomerModel) Update(customer *Customer) error {
 	return nil
 }
 
-// Delete removes a customer from the database.
-func (m CustomerModel) Delete(id int64) error {
+// AssignUnmanaged makes the user the account manager of every customer without one,
+// returning how many customers were assigned.
+func (m CustomerModel) AssignUnmanaged(accountManagerID int64) (int64, error) {
+	query := `
+	UPDATE customers
+	SET account_manager_id = $1, version = version + 1
+	WHERE account_manager_id IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	results, err := m.DB.ExecContext(ctx, query, accountManagerID)
+	if err != nil {
+		log.Println("Assigning customers", err)
+		return 0, err
+	}
+	return results.RowsAffected()
+}
+
+// Delete removes a customer within the scope from the database.
+func (m CustomerModel) Delete(id int64, scope CustomerScope) error {
 	query := `
 	DELETE FROM customers
-	WHERE id = $1
+	WHERE id = $1 AND ($2::bigint = 0 OR account_manager_id = $2)
 	`
 
-	results, err := m.DB.Exec(query, id)
+	results, err := m.DB.Exec(query, id, scope.AccountManagerID)
 	if err != nil {
 		log.Println("Delete operation", err)
 		return err
@@ -0,0 +1,236 @@
+package data
+
+import (
+	"company/internal/validator"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ErrMissingExchangeRate is returned when an amount has to be converted on a date for
+// which no exchange rate has been loaded yet.
+var ErrMissingExchangeRate = errors.New("missing exchange rate")
+
+// ExchangeRate is the value of one unit of Currency in BaseCurrency, effective from
+// EffectiveOn until the next rate of the pair.
+type ExchangeRate struct {
+	Currency     string    `json:"currency"`
+	BaseCurrency string    `json:"base_currency"`
+	Rate         string    `json:"rate"` // Decimal, e.g. "83.1250"
+	EffectiveOn  time.Time `json:"effective_on"`
+}
+
+// MissingExchangeRateError tells which rate was missing to convert an amount.
+type MissingExchangeRateError struct {
+	Currency     string
+	BaseCurrency string
+	Date         time.Time
+}
+
+func (e *MissingExchangeRateError) Error() string {
+	return fmt.Sprintf("no exchange rate from %s to %s on %s", e.Currency, e.BaseCurrency, e.Date.Format(time.DateOnly))
+}
+
+func (e *MissingExchangeRateError) Unwrap() error {
+	return ErrMissingExchangeRate
+}
+
+func ValidateExchangeRate(v *validator.Validator, rate *ExchangeRate) {
+	v.Check(ValidCurrency(rate.Currency), "currency", "must be a supported ISO-4217 currency")
+	v.Check(ValidCurrency(rate.BaseCurrency), "base_currency", "must be a supported ISO-4217 currency")
+	v.Check(rate.Currency != rate.BaseCurrency, "currency", "must differ from the base currency")
+	// Rates are stored as NUMERIC(20, 10), with up to 10 digits before the point
+	r, ok := parseDecimal(rate.Rate)
+	v.Check(ok && r.Sign() > 0, "rate", "must be a positive decimal number")
+	v.Check(!ok || r.Cmp(big.NewRat(10_000_000_000, 1)) < 0, "rate", "must be less than 10000000000")
+	v.Check(!rate.EffectiveOn.IsZero(), "effective_on", "must be provided")
+}
+
+// ParseExchangeRatesCSV reads exchange rates from CSV with a header row naming the
+// columns date (YYYY-MM-DD), currency and rate, plus an optional base_currency
+// column. Rates without a base currency are taken to be in baseCurrency.
+func ParseExchangeRatesCSV(r io.Reader, baseCurrency string) ([]*ExchangeRate, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"date", "currency", "rate"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing %q column", name)
+		}
+	}
+
+	rates := []*ExchangeRate{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		date, err := time.Parse(time.DateOnly, record[columns["date"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, record[columns["date"]])
+		}
+		rate := &ExchangeRate{
+			Currency:     strings.ToUpper(record[columns["currency"]]),
+			BaseCurrency: baseCurrency,
+			Rate:         record[columns["rate"]],
+			EffectiveOn:  date,
+		}
+		if i, ok := columns["base_currency"]; ok && record[i] != "" {
+			rate.BaseCurrency = strings.ToUpper(record[i])
+		}
+
+		v := validator.New()
+		if ValidateExchangeRate(v, rate); !v.Valid() {
+			for field, message := range v.Errors {
+				return nil, fmt.Errorf("line %d: %s %s", line, field, message)
+			}
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+// Convert converts an amount into the target currency at the given rate, which is
+// the value of one unit of the amount's currency. The result is rounded half away
+// from zero to the minor units of the target currency.
+func Convert(amount Money, rate *big.Rat, currency string) (Money, error) {
+	value := new(big.Rat).Mul(new(big.Rat).SetInt64(amount.Amount), rate)
+	return rescale(value, amount.Currency, currency)
+}
+
+// rescale turns a value counted in minor units of one currency into whole minor
+// units of another one, the value having already been multiplied by the rate.
+func rescale(value *big.Rat, from, to string) (Money, error) {
+	if !ValidCurrency(from) || !ValidCurrency(to) {
+		return Money{}, ErrUnknownCurrency
+	}
+	scale := currencyExponents[to] - currencyExponents[from]
+	factor := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(scale))), nil))
+	if scale >= 0 {
+		value = new(big.Rat).Mul(value, factor)
+	} else {
+		value = new(big.Rat).Quo(value, factor)
+	}
+
+	minor, err := roundRat(value)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: minor, Currency: to}, nil
+}
+
+// roundRat rounds a rational number half away from zero.
+func roundRat(value *big.Rat) (int64, error) {
+	numerator := new(big.Int).Abs(value.Num())
+	quotient, remainder := new(big.Int).QuoRem(numerator, value.Denom(), new(big.Int))
+	if remainder.Mul(remainder, big.NewInt(2)).Cmp(value.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	if value.Sign() < 0 {
+		quotient.Neg(quotient)
+	}
+	if !quotient.IsInt64() {
+		return 0, ErrAmountOverflow
+	}
+	return quotient.Int64(), nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+type ExchangeRateModel struct {
+	DB *sql.DB
+}
+
+// Insert stores the exchange rates in a single transaction, replacing the rates
+// already stored for the same pair and date.
+func (m ExchangeRateModel) Insert(rates []*ExchangeRate) error {
+	query := `
+	INSERT INTO exchange_rates (currency, base_currency, rate, effective_on)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (currency, base_currency, effective_on) DO UPDATE SET rate = EXCLUDED.rate
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, rate := range rates {
+		_, err = tx.ExecContext(ctx, query, rate.Currency, rate.BaseCurrency, rate.Rate, rate.EffectiveOn)
+		if err != nil {
+			log.Println("Inserting exchange rate", err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetAll fetches a page of the exchange rates into the base currency, the most
+// recent first, optionally only those of one currency.
+func (m ExchangeRateModel) GetAll(currency, baseCurrency string, filters Filters) ([]*ExchangeRate, Metadata, error) {
+	query := `
+	SELECT count(*) OVER(), currency, base_currency, rate::text, effective_on
+	FROM exchange_rates
+	WHERE base_currency = $1 AND ($2::text = '' OR currency = $2)
+	ORDER BY effective_on DESC, currency ASC
+	LIMIT $3 OFFSET $4
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, baseCurrency, currency, filters.limit(), filters.offset())
+	if err != nil {
+		log.Println("Error getting exchange rates", err)
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	rates := []*ExchangeRate{}
+
+	for rows.Next() {
+		var rate ExchangeRate
+
+		err = rows.Scan(&totalRecords, &rate.Currency, &rate.BaseCurrency, &rate.Rate, &rate.EffectiveOn)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		rates = append(rates, &rate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return rates, metadata, nil
+}
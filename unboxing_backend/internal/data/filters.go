@@ -1,6 +1,10 @@
 package data
 
-import "company/internal/validator"
+import (
+	"company/internal/validator"
+	"math"
+	"strings"
+)
 
 type Filters struct {
 	Page         int
@@ -9,6 +13,36 @@ type Filters struct {
 	SortSafelist []string
 }
 
+// sortColumn() checks that the client-provided Sort field matches one of the entries
+// in the safelist, and if it does, extracts the column name from the Sort field by
+// stripping the leading hyphen character (if one exists). The column name ends up in
+// the SQL query, so anything not on the safelist is a bug and we panic.
+func (f Filters) sortColumn() string {
+	for _, safeValue := range f.SortSafelist {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+// sortDirection() returns the sort direction ("ASC" or "DESC") depending on the prefix
+// character of the Sort field.
+func (f Filters) sortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
 	// Check that the page and page_size parameters contain sensible values.
 	v.Check(f.Page > 0, "page", "must be greater than zero")
@@ -18,3 +52,28 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	// Check that the sort parameter matches a value in the safelist.
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
 }
+
+// Metadata holds the pagination details returned alongside a page of records.
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty"`
+}
+
+// calculateMetadata() works out the pagination metadata from the total number of
+// records matching the query. When there are no records an empty Metadata is
+// returned, which the omitempty directives turn into an empty JSON object.
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
@@ -0,0 +1,709 @@
+package data
+
+import (
+	"company/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Invoices start as drafts, which can be changed freely, and are then issued to the
+// customer. Payments make them partially paid then paid, and so do credit notes
+// taking off what is left to pay. Issued invoices can't be changed or deleted
+// anymore, they are voided or credited instead. Overdue is never stored: an
+// issued or partially paid invoice reads as overdue once its due date has passed.
+const (
+	InvoiceDraft         = "draft"
+	InvoiceIssued        = "issued"
+	InvoicePartiallyPaid = "partially_paid"
+	InvoicePaid          = "paid"
+	InvoiceVoid          = "void"
+	InvoiceOverdue       = "overdue"
+)
+
+// InvoiceStatuses lists every status an invoice can be read with.
+func InvoiceStatuses() []string {
+	return []string{InvoiceDraft, InvoiceIssued, InvoicePartiallyPaid, InvoicePaid, InvoiceVoid, InvoiceOverdue}
+}
+
+// invoiceTransitions lists the statuses an invoice can be moved to by hand, from each
+// status. Paid and partially paid are only reached by recording payments.
+var invoiceTransitions = map[string][]string{
+	InvoiceDraft:   {InvoiceIssued, InvoiceVoid},
+	InvoiceIssued:  {InvoiceVoid},
+	InvoiceOverdue: {InvoiceVoid},
+}
+
+// DefaultPaymentTerms is the number of days customers have to pay an invoice which
+// was created without a due date.
+const DefaultPaymentTerms = 30
+
+// ErrInvoiceHasLines is returned when the amount of an invoice with several lines is
+// changed through its billing summary, which can't tell which line to change.
+var ErrInvoiceHasLines = errors.New("invoice has several lines")
+
+var (
+	ErrInvoiceNotEditable = errors.New("invoice is not a draft")
+	ErrInvalidTransition  = errors.New("invalid invoice status transition")
+)
+
+// InvoiceLine is one item of an invoice. Net, Tax and Total are computed from the
+// other fields by Invoice.CalculateTotals. Lines with a tax category get the rate of
+// the category when the invoice is saved.
+type InvoiceLine struct {
+	ID          int64  `json:"id"`
+	Description string `json:"description"`
+	Quantity    string `json:"quantity"` // Decimal, e.g. "1.5"
+	UnitPrice   Money  `json:"unit_price"`
+	TaxCategory string `json:"tax_category,omitempty"`
+	TaxRate     string `json:"tax_rate"` // Percentage, e.g. "18"
+	Net         Money  `json:"net"`      // Quantity times unit price
+	Tax         Money  `json:"tax"`      // Nothing under reverse charge
+	Total       Money  `json:"total"`
+}
+
+type Invoice struct {
+	ID             int64          `json:"id"`
+	Series         string         `json:"series"`
+	Number         string         `json:"number,omitempty"` // Allocated when issued
+	CustomerID     int64          `json:"customer_id"`
+	Currency       string         `json:"currency"`
+	IssueDate      time.Time      `json:"issue_date"`
+	DueDate        time.Time      `json:"due_date"`
+	Status         string         `json:"status"`
+	TaxTreatment   string         `json:"tax_treatment"` // That of the customer when saved
+	Lines          []*InvoiceLine `json:"lines,omitempty"`
+	Taxes          []*TaxAmount   `json:"taxes,omitempty"` // Tax breakdown of the lines
+	Subtotal       Money          `json:"subtotal"`
+	TaxTotal       Money          `json:"tax_total"`
+	Total          Money          `json:"total"`
+	AmountPaid     Money          `json:"amount_paid"`     // Net of refunds
+	AmountCredited Money          `json:"amount_credited"` // By credit notes
+	Balance        Money          `json:"balance"`         // Total left to pay
+	Version        int32          `json:"version"`
+}
+
+// Editable reports whether the invoice can still be changed or deleted.
+func (i *Invoice) Editable() bool {
+	return i.Status == InvoiceDraft
+}
+
+// Payable reports whether payments can be recorded against the invoice.
+func (i *Invoice) Payable() bool {
+	return validator.In(i.Status, InvoiceIssued, InvoicePartiallyPaid, InvoiceOverdue)
+}
+
+// CanTransition reports whether the invoice can be moved to the status by hand.
+// Invoices which received payments or were credited can't be voided.
+func (i *Invoice) CanTransition(status string) bool {
+	if status == InvoiceVoid && (!i.AmountPaid.IsZero() || !i.AmountCredited.IsZero()) {
+		return false
+	}
+	return validator.In(status, invoiceTransitions[i.Status]...)
+}
+
+// setBalance works out what is left to pay on the invoice.
+func (i *Invoice) setBalance() {
+	i.Balance = NewMoney(i.Total.Amount-i.AmountPaid.Amount-i.AmountCredited.Amount, i.Currency)
+}
+
+// Creditable reports whether credit notes can be issued against the invoice.
+func (i *Invoice) Creditable() bool {
+	return i.Payable() || i.Status == InvoicePaid
+}
+
+// CalculateTotals works out the amounts of every line, the totals of the invoice and
+// its tax breakdown. Each line amount is rounded to minor units on its own, so that
+// the invoice total is always the sum of what is printed on the lines. Under reverse
+// charge the tax is only part of the breakdown.
+func (i *Invoice) CalculateTotals() error {
+	i.Subtotal = Money{Currency: i.Currency}
+	i.TaxTotal = Money{Currency: i.Currency}
+	i.Taxes = nil
+
+	for _, line := range i.Lines {
+		quantity, ok := parseDecimal(line.Quantity)
+		if !ok {
+			return fmt.Errorf("invalid quantity %q", line.Quantity)
+		}
+		rate, ok := parseDecimal(line.TaxRate)
+		if !ok {
+			return fmt.Errorf("invalid tax rate %q", line.TaxRate)
+		}
+		rate.Quo(rate, big.NewRat(100, 1))
+
+		var err error
+		if line.Net, err = line.UnitPrice.MulRat(quantity); err != nil {
+			return err
+		}
+		tax, err := line.Net.MulRat(rate)
+		if err != nil {
+			return err
+		}
+		if err = i.addTax(line, tax); err != nil {
+			return err
+		}
+		line.Tax = tax
+		if i.TaxTreatment == TaxReverseCharge {
+			line.Tax = Money{Currency: i.Currency}
+		}
+		if line.Total, err = line.Net.Add(line.Tax); err != nil {
+			return err
+		}
+		if i.Subtotal, err = i.Subtotal.Add(line.Net); err != nil {
+			return err
+		}
+		if i.TaxTotal, err = i.TaxTotal.Add(line.Tax); err != nil {
+			return err
+		}
+	}
+
+	total, err := i.Subtotal.Add(i.TaxTotal)
+	if err != nil {
+		return err
+	}
+	i.Total = total
+	return nil
+}
+
+// addTax adds the net amount and the tax of the line to the tax breakdown entry of
+// its category and rate.
+func (i *Invoice) addTax(line *InvoiceLine, tax Money) error {
+	rate := trimDecimal(line.TaxRate)
+	var entry *TaxAmount
+	for _, t := range i.Taxes {
+		if t.Category == line.TaxCategory && t.Rate == rate {
+			entry = t
+			break
+		}
+	}
+	if entry == nil {
+		entry = &TaxAmount{
+			Category:      line.TaxCategory,
+			Rate:          rate,
+			Taxable:       Money{Currency: i.Currency},
+			Tax:           Money{Currency: i.Currency},
+			ReverseCharge: i.TaxTreatment == TaxReverseCharge,
+		}
+		i.Taxes = append(i.Taxes, entry)
+	}
+
+	var err error
+	if entry.Taxable, err = entry.Taxable.Add(line.Net); err != nil {
+		return err
+	}
+	entry.Tax, err = entry.Tax.Add(tax)
+	return err
+}
+
+// ValidateInvoice checks the invoice, whose series must be one the numbering allows
+// for invoices.
+func ValidateInvoice(v *validator.Validator, invoice *Invoice, numbering Numbering) {
+	v.Check(invoice.CustomerID > 0, "customer_id", "must be provided")
+	numbering.ValidateInvoiceSeries(v, invoice.Series)
+	v.Check(ValidCurrency(invoice.Currency), "currency", "must be a supported ISO-4217 currency")
+	v.Check(!invoice.IssueDate.IsZero(), "issue_date", "must be provided")
+	v.Check(!invoice.DueDate.Before(invoice.IssueDate.Truncate(24*time.Hour)), "due_date", "must not be before the issue date")
+	v.Check(validator.In(invoice.Status, InvoiceStatuses()...), "status", "invalid status")
+	v.Check(len(invoice.Lines) > 0, "lines", "must contain at least one line")
+	v.Check(len(invoice.Lines) <= 100, "lines", "must not contain more than 100 lines")
+
+	for n, line := range invoice.Lines {
+		key := fmt.Sprintf("lines[%d]", n)
+		v.Check(strings.TrimSpace(line.Description) != "", key+".description", "must be provided")
+		v.Check(len(line.Description) <= 500, key+".description", "must not be more than 500 bytes long")
+		// Quantities are stored as NUMERIC(12, 3)
+		quantity, ok := parseDecimal(line.Quantity)
+		v.Check(ok && quantity.Sign() > 0, key+".quantity", "must be a positive decimal number")
+		v.Check(!ok || quantity.Cmp(big.NewRat(1_000_000_000, 1)) < 0, key+".quantity", "must be less than 1000000000")
+		v.Check(!ok || decimalPlaces(line.Quantity) <= 3, key+".quantity", "must not have more than 3 decimals")
+		v.Check(line.UnitPrice.Currency == invoice.Currency, key+".unit_price", "must be in the currency of the invoice")
+		v.Check(!line.UnitPrice.IsNegative(), key+".unit_price", "must not be negative")
+		if line.TaxCategory != "" {
+			ValidateTaxCategory(v, key+".tax_category", line.TaxCategory)
+			// The rate is that of the category, set when the invoice is saved
+			continue
+		}
+		validatePercentage(v, key+".tax_rate", line.TaxRate)
+	}
+}
+
+// validatePercentage checks the tax rate is a percentage between 0 and 100 that fits
+// the NUMERIC(6, 3) columns rates are stored in, which would otherwise round it.
+func validatePercentage(v *validator.Validator, key, rate string) {
+	r, ok := parseDecimal(rate)
+	v.Check(ok && r.Sign() >= 0 && r.Cmp(big.NewRat(100, 1)) <= 0, key, "must be a percentage between 0 and 100")
+	v.Check(!ok || decimalPlaces(rate) <= 3, key, "must not have more than 3 decimals")
+}
+
+// parseDecimal parses a plain decimal number such as "12" or "0.125", rejecting the
+// fractions and exponents big.Rat would otherwise accept.
+func parseDecimal(s string) (*big.Rat, bool) {
+	if s == "" || strings.Trim(s, "0123456789.") != "" || strings.Count(s, ".") > 1 {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// decimalPlaces counts the significant digits after the decimal point of a plain
+// decimal number, trailing zeros not included.
+func decimalPlaces(s string) int {
+	_, fraction, _ := strings.Cut(s, ".")
+	return len(strings.TrimRight(fraction, "0"))
+}
+
+// trimDecimal drops the trailing zeros NUMERIC columns are formatted with.
+func trimDecimal(s string) string {
+	if !strings.Contains(s, ".") {
+		return s
+	}
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// invoiceColumns are the columns scanned by scanInvoice.
+const invoiceColumns = `invoices.id, invoices.series, coalesce(invoices.number, ''), invoices.customer_id, invoices.currency,
+	invoices.issue_date, invoices.due_date, invoice_status(invoices.status, invoices.due_date), invoices.tax_treatment,
+	invoices.subtotal, invoices.tax_total, invoices.total, invoices.amount_paid, invoices.amount_credited, invoices.version`
+
+// scanInvoice scans invoiceColumns, preceded by the destinations in extra.
+func scanInvoice(row interface{ Scan(...interface{}) error }, invoice *Invoice, extra ...interface{}) error {
+	dest := append(extra,
+		&invoice.ID,
+		&invoice.Series,
+		&invoice.Number,
+		&invoice.CustomerID,
+		&invoice.Currency,
+		&invoice.IssueDate,
+		&invoice.DueDate,
+		&invoice.Status,
+		&invoice.TaxTreatment,
+		&invoice.Subtotal,
+		&invoice.TaxTotal,
+		&invoice.Total,
+		&invoice.AmountPaid,
+		&invoice.AmountCredited,
+		&invoice.Version,
+	)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	invoice.Subtotal.Currency = invoice.Currency
+	invoice.TaxTotal.Currency = invoice.Currency
+	invoice.Total.Currency = invoice.Currency
+	invoice.AmountPaid.Currency = invoice.Currency
+	invoice.AmountCredited.Currency = invoice.Currency
+	invoice.setBalance()
+	return nil
+}
+
+type InvoiceModel struct {
+	DB        *sql.DB
+	Numbering Numbering
+}
+
+// GetAll fetches a page of the invoices of the customers visible in the scope,
+// without their lines. customerID 0 and an empty status mean no filtering.
+func (m InvoiceModel) GetAll(customerID int64, status string, scope CustomerScope, filters Filters) ([]*Invoice, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), %s
+	FROM invoices
+	INNER JOIN customers ON customers.id = invoices.customer_id
+	WHERE ($1::bigint = 0 OR customers.account_manager_id = $1)
+	AND ($2::bigint = 0 OR invoices.customer_id = $2)
+	AND ($3::text = '' OR invoice_status(invoices.status, invoices.due_date) = $3)
+	ORDER BY invoices.%s %s, invoices.id ASC
+	LIMIT $4 OFFSET $5
+	`, invoiceColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	args := []interface{}{scope.AccountManagerID, customerID, status, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println("Error getting invoices", err)
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	invoices := []*Invoice{}
+
+	for rows.Next() {
+		var invoice Invoice
+
+		err = scanInvoice(rows, &invoice, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		invoices = append(invoices, &invoice)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return invoices, metadata, nil
+}
+
+// Get fetches an invoice with its lines. Invoices of customers outside of the scope
+// are reported as not found.
+func (m InvoiceModel) Get(id int64, scope CustomerScope) (*Invoice, error) {
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM invoices
+	INNER JOIN customers ON customers.id = invoices.customer_id
+	WHERE invoices.id = $1 AND ($2::bigint = 0 OR customers.account_manager_id = $2)
+	`, invoiceColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var invoice Invoice
+	err := scanInvoice(m.DB.QueryRowContext(ctx, query, id, scope.AccountManagerID), &invoice)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			log.Println("Getting invoice", err)
+			return nil, err
+		}
+	}
+
+	err = m.getLines(ctx, &invoice)
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// getLines fetches the lines of the invoice and works out their amounts and the tax
+// breakdown of the invoice.
+func (m InvoiceModel) getLines(ctx context.Context, invoice *Invoice) error {
+	query := `
+	SELECT id, description, quantity::text, unit_price, coalesce(tax_category, ''), tax_rate::text
+	FROM invoice_lines
+	WHERE invoice_id = $1
+	ORDER BY position
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, invoice.ID)
+	if err != nil {
+		log.Println("Getting invoice lines", err)
+		return err
+	}
+	defer rows.Close()
+
+	lines := []*InvoiceLine{}
+	for rows.Next() {
+		line := InvoiceLine{UnitPrice: Money{Currency: invoice.Currency}}
+
+		err = rows.Scan(&line.ID, &line.Description, &line.Quantity, &line.UnitPrice, &line.TaxCategory, &line.TaxRate)
+		if err != nil {
+			return err
+		}
+		line.Quantity = trimDecimal(line.Quantity)
+		line.TaxRate = trimDecimal(line.TaxRate)
+		lines = append(lines, &line)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// The line amounts aren't stored, compute them the way they were when saved. The
+	// totals come out the same as those stored.
+	invoice.Lines = lines
+	return invoice.CalculateTotals()
+}
+
+// Insert adds a new invoice with its lines, taxed according to the tax treatment of
+// the customer and the rates in force on the issue date. Invoices which aren't drafts
+// get the next number of their series. It fails with an UnknownTaxCategoryError when
+// a line refers to a category without a rate.
+func (m InvoiceModel) Insert(invoice *Invoice) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = m.insert(ctx, tx, invoice); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Invoice with ID: %d created successfully in the database\n", invoice.ID)
+	return nil
+}
+
+// insert adds the invoice as Insert does, as part of the transaction.
+func (m InvoiceModel) insert(ctx context.Context, tx *sql.Tx, invoice *Invoice) error {
+	query := `
+	INSERT INTO invoices (series, number, customer_id, currency, issue_date, due_date, status, tax_treatment, subtotal, tax_total, total,
+		issued_at, voided_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11,
+		CASE WHEN $7 <> 'draft' THEN now() END, CASE WHEN $7 = 'void' THEN now() END)
+	RETURNING id, version
+	`
+
+	err := applyTaxes(ctx, tx, invoice)
+	if err != nil {
+		return err
+	}
+	number := sql.NullString{}
+	if invoice.Status != InvoiceDraft {
+		number.String, err = m.Numbering.Next(ctx, tx, invoice.Series, invoice.IssueDate)
+		if err != nil {
+			return err
+		}
+		number.Valid = true
+	}
+
+	args := []interface{}{
+		invoice.Series,
+		number,
+		invoice.CustomerID,
+		invoice.Currency,
+		invoice.IssueDate,
+		invoice.DueDate,
+		invoice.Status,
+		invoice.TaxTreatment,
+		invoice.Subtotal,
+		invoice.TaxTotal,
+		invoice.Total,
+	}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&invoice.ID, &invoice.Version)
+	if err != nil {
+		log.Println("Creating invoice in the database", err)
+		return err
+	}
+	if err = insertInvoiceLines(ctx, tx, invoice); err != nil {
+		return err
+	}
+	invoice.Number = number.String
+	invoice.AmountPaid = Money{Currency: invoice.Currency}
+	invoice.AmountCredited = Money{Currency: invoice.Currency}
+	invoice.Balance = invoice.Total
+	return nil
+}
+
+func insertInvoiceLines(ctx context.Context, tx *sql.Tx, invoice *Invoice) error {
+	query := `
+	INSERT INTO invoice_lines (invoice_id, position, description, quantity, unit_price, tax_category, tax_rate)
+	VALUES ($1, $2, $3, $4, $5, nullif($6, ''), $7)
+	RETURNING id
+	`
+
+	for position, line := range invoice.Lines {
+		args := []interface{}{invoice.ID, position + 1, line.Description, line.Quantity, line.UnitPrice, line.TaxCategory, line.TaxRate}
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&line.ID)
+		if err != nil {
+			log.Println("Creating invoice line in the database", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Update saves a draft invoice and replaces its lines, unless it was changed since it
+// was read. Taxes are applied again as by Insert. The status is changed by Transition
+// and by recording payments only.
+func (m InvoiceModel) Update(invoice *Invoice) error {
+	query := `
+	UPDATE invoices
+	SET series = $1, customer_id = $2, currency = $3, issue_date = $4, due_date = $5,
+		tax_treatment = $6, subtotal = $7, tax_total = $8, total = $9, version = version + 1
+	WHERE id = $10 AND version = $11 AND status = 'draft'
+	RETURNING version
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = applyTaxes(ctx, tx, invoice); err != nil {
+		return err
+	}
+	args := []interface{}{
+		invoice.Series,
+		invoice.CustomerID,
+		invoice.Currency,
+		invoice.IssueDate,
+		invoice.DueDate,
+		invoice.TaxTreatment,
+		invoice.Subtotal,
+		invoice.TaxTotal,
+		invoice.Total,
+		invoice.ID,
+		invoice.Version,
+	}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&invoice.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			log.Println("Updating invoice", err)
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM invoice_lines WHERE invoice_id = $1`, invoice.ID)
+	if err != nil {
+		log.Println("Deleting invoice lines", err)
+		return err
+	}
+	if err = insertInvoiceLines(ctx, tx, invoice); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Transition moves the invoice to the status by hand, unless it was changed since it
+// was read. See CanTransition for the transitions allowed. Issuing a draft gives it
+// the next number of its series, and dates it the day it is issued so that numbers
+// follow the order of issue dates within the fiscal year of the issue.
+func (m InvoiceModel) Transition(invoice *Invoice, status string) error {
+	if !invoice.CanTransition(status) {
+		return ErrInvalidTransition
+	}
+	query := `
+	UPDATE invoices
+	SET status = $1, number = coalesce(number, $2),
+		issued_at = CASE WHEN $1 = 'issued' THEN now() ELSE issued_at END,
+		voided_at = CASE WHEN $1 = 'void' THEN now() ELSE voided_at END,
+		version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING invoice_status(status, due_date), coalesce(number, ''), version
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	number := sql.NullString{}
+	if status == InvoiceIssued && invoice.Number == "" {
+		if err = m.redate(ctx, tx, invoice, time.Now().UTC().Truncate(24*time.Hour)); err != nil {
+			return err
+		}
+		number.String, err = m.Numbering.Next(ctx, tx, invoice.Series, invoice.IssueDate)
+		if err != nil {
+			return err
+		}
+		number.Valid = true
+	}
+
+	args := []interface{}{status, number, invoice.ID, invoice.Version}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&invoice.Status, &invoice.Number, &invoice.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			log.Println("Changing invoice status", err)
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Invoice with ID: %d is now %s\n", invoice.ID, invoice.Status)
+	return nil
+}
+
+// redate moves the issue date of a draft invoice to the date, shifting its due date
+// by as much, as part of the transaction issuing it. Taxes are applied again with
+// the rates in force on the new date.
+func (m InvoiceModel) redate(ctx context.Context, tx *sql.Tx, invoice *Invoice, date time.Time) error {
+	if invoice.IssueDate.Truncate(24 * time.Hour).Equal(date) {
+		return nil
+	}
+	invoice.DueDate = invoice.DueDate.Add(date.Sub(invoice.IssueDate.Truncate(24 * time.Hour)))
+	invoice.IssueDate = date
+	if err := applyTaxes(ctx, tx, invoice); err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE invoices
+	SET issue_date = $1, due_date = $2, tax_treatment = $3, subtotal = $4, tax_total = $5, total = $6
+	WHERE id = $7 AND version = $8 AND status = 'draft'
+	`
+	args := []interface{}{invoice.IssueDate, invoice.DueDate, invoice.TaxTreatment, invoice.Subtotal, invoice.TaxTotal, invoice.Total, invoice.ID, invoice.Version}
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		log.Println("Redating invoice", err)
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrEditConflict
+	}
+
+	for _, line := range invoice.Lines {
+		_, err = tx.ExecContext(ctx, `UPDATE invoice_lines SET tax_rate = $1 WHERE id = $2`, line.TaxRate, line.ID)
+		if err != nil {
+			log.Println("Updating tax rate of invoice line", err)
+			return err
+		}
+	}
+	invoice.setBalance()
+	return nil
+}
+
+// Delete removes a draft invoice of a customer within the scope, and its lines.
+// Invoices which were issued have to be voided instead.
+func (m InvoiceModel) Delete(id int64, scope CustomerScope) error {
+	query := `
+	DELETE FROM invoices
+	USING customers
+	WHERE customers.id = invoices.customer_id
+	AND invoices.id = $1 AND ($2::bigint = 0 OR customers.account_manager_id = $2)
+	AND invoices.status = 'draft'
+	`
+
+	results, err := m.DB.Exec(query, id, scope.AccountManagerID)
+	if err != nil {
+		log.Println("Delete operation", err)
+		return err
+	}
+
+	rowsAffected, err := results.RowsAffected()
+	if err != nil {
+		log.Println("Error checking rows affected", err)
+		return err
+	}
+
+	if rowsAffected == 0 {
+		// Tell apart missing invoices from those which aren't drafts
+		_, err = m.Get(id, scope)
+		if err != nil {
+			return err
+		}
+		return ErrInvoiceNotEditable
+	}
+
+	return nil
+}
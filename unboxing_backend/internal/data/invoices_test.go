@@ -0,0 +1,222 @@
+package data
+
+import (
+	"company/internal/validator"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // As a big.Rat, "" when rejected
+	}{
+		{"12", "12/1"},
+		{"0.125", "1/8"},
+		{"1.50", "3/2"},
+		{"007", "7/1"},
+		{".5", "1/2"},
+		{"", ""},
+		{"1/3", ""},
+		{"1e5", ""},
+		{"1E5", ""},
+		{"-1", ""},
+		{"+1", ""},
+		{"1.2.3", ""},
+		{" 1", ""},
+		{"0x10", ""},
+		{"Inf", ""},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseDecimal(tt.input)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("parseDecimal(%q) = %s, want it rejected", tt.input, got)
+			}
+			continue
+		}
+		if !ok || got.String() != tt.want {
+			t.Errorf("parseDecimal(%q) = %v, %t, want %s", tt.input, got, ok, tt.want)
+		}
+	}
+}
+
+func TestTrimDecimal(t *testing.T) {
+	tests := map[string]string{
+		"18.0000": "18",
+		"12.5000": "12.5",
+		"0.0000":  "0",
+		"100":     "100",
+		"0.125":   "0.125",
+	}
+	for input, want := range tests {
+		if got := trimDecimal(input); got != want {
+			t.Errorf("trimDecimal(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestDecimalPlaces(t *testing.T) {
+	tests := map[string]int{
+		"12":     0,
+		"12.":    0,
+		"12.5":   1,
+		"0.125":  3,
+		"1.0004": 4,
+		"18.000": 0,
+		"1.2500": 2,
+	}
+	for input, want := range tests {
+		if got := decimalPlaces(input); got != want {
+			t.Errorf("decimalPlaces(%q) = %d, want %d", input, got, want)
+		}
+	}
+}
+
+func TestValidateInvoiceLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		quantity string
+		rate     string
+		errors   []string
+	}{
+		{"valid", "1.5", "18", nil},
+		{"three decimals", "0.125", "12.125", nil},
+		{"trailing zeros", "2.5000", "18.0000", nil},
+		{"largest quantity", "999999999.999", "0", nil},
+		{"quantity too precise", "1.0004", "18", []string{"lines[0].quantity"}},
+		{"quantity too large", "1000000000", "18", []string{"lines[0].quantity"}},
+		{"quantity zero", "0", "18", []string{"lines[0].quantity"}},
+		{"quantity exponent", "1e9", "18", []string{"lines[0].quantity"}},
+		{"rate too precise", "1", "18.0004", []string{"lines[0].tax_rate"}},
+		{"rate over 100", "1", "100.001", []string{"lines[0].tax_rate"}},
+		{"rate fraction", "1", "1/3", []string{"lines[0].tax_rate"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice := &Invoice{
+				CustomerID: 1,
+				Series:     "INV",
+				Currency:   "INR",
+				IssueDate:  time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC),
+				DueDate:    time.Date(2026, time.May, 1, 0, 0, 0, 0, time.UTC),
+				Status:     InvoiceDraft,
+				Lines: []*InvoiceLine{
+					{Description: "Consulting", Quantity: tt.quantity, UnitPrice: NewMoney(10000, "INR"), TaxRate: tt.rate},
+				},
+			}
+			v := validator.New()
+			ValidateInvoice(v, invoice, Numbering{})
+			if len(v.Errors) != len(tt.errors) {
+				t.Fatalf("errors = %v, want errors on %v", v.Errors, tt.errors)
+			}
+			for _, key := range tt.errors {
+				if _, ok := v.Errors[key]; !ok {
+					t.Errorf("errors = %v, want an error on %s", v.Errors, key)
+				}
+			}
+		})
+	}
+}
+
+// sqlLineAmounts rounds a line the way the TaxSummary query does, with
+// round(quantity * unit_price) and round(round(quantity * unit_price) * tax_rate / 100).
+func sqlLineAmounts(t *testing.T, line *InvoiceLine) (int64, int64) {
+	t.Helper()
+	quantity, _ := new(big.Rat).SetString(line.Quantity)
+	rate, _ := new(big.Rat).SetString(line.TaxRate)
+	net, err := roundRat(new(big.Rat).Mul(quantity, new(big.Rat).SetInt64(line.UnitPrice.Amount)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tax, err := roundRat(new(big.Rat).Quo(new(big.Rat).Mul(new(big.Rat).SetInt64(net), rate), big.NewRat(100, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return net, tax
+}
+
+func TestInvoiceCalculateTotals(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []*InvoiceLine
+		subtotal int64
+		taxTotal int64
+	}{
+		{
+			name:     "whole quantities",
+			lines:    []*InvoiceLine{{Quantity: "2", UnitPrice: NewMoney(10000, "INR"), TaxRate: "18"}},
+			subtotal: 20000,
+			taxTotal: 3600,
+		},
+		{
+			name:     "net rounded half away from zero",
+			lines:    []*InvoiceLine{{Quantity: "0.5", UnitPrice: NewMoney(5, "INR"), TaxRate: "0"}},
+			subtotal: 3,
+			taxTotal: 0,
+		},
+		{
+			name:     "tax rounded on the rounded net",
+			lines:    []*InvoiceLine{{Quantity: "1.5", UnitPrice: NewMoney(333, "INR"), TaxRate: "18"}},
+			subtotal: 500, // 499.5
+			taxTotal: 90,  // 18% of 500, not of 499.5
+		},
+		{
+			name: "each line rounded on its own",
+			lines: []*InvoiceLine{
+				{Quantity: "1", UnitPrice: NewMoney(5, "INR"), TaxRate: "10"},
+				{Quantity: "1", UnitPrice: NewMoney(5, "INR"), TaxRate: "10"},
+			},
+			subtotal: 10,
+			taxTotal: 2, // 0.5 twice, where 10% of the subtotal would be 1
+		},
+		{
+			name:     "fractional rate",
+			lines:    []*InvoiceLine{{Quantity: "3", UnitPrice: NewMoney(999, "INR"), TaxRate: "12.5"}},
+			subtotal: 2997,
+			taxTotal: 375, // 374.625
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice := &Invoice{Currency: "INR", TaxTreatment: TaxStandard, Lines: tt.lines}
+			if err := invoice.CalculateTotals(); err != nil {
+				t.Fatal(err)
+			}
+			if invoice.Subtotal.Amount != tt.subtotal || invoice.TaxTotal.Amount != tt.taxTotal {
+				t.Errorf("subtotal, tax = %d, %d, want %d, %d", invoice.Subtotal.Amount, invoice.TaxTotal.Amount, tt.subtotal, tt.taxTotal)
+			}
+			if invoice.Total.Amount != tt.subtotal+tt.taxTotal {
+				t.Errorf("total = %d, want %d", invoice.Total.Amount, tt.subtotal+tt.taxTotal)
+			}
+			for n, line := range invoice.Lines {
+				net, tax := sqlLineAmounts(t, line)
+				if line.Net.Amount != net || line.Tax.Amount != tax {
+					t.Errorf("line %d = %d, %d, the tax summary would read %d, %d", n, line.Net.Amount, line.Tax.Amount, net, tax)
+				}
+				if line.Total.Amount != line.Net.Amount+line.Tax.Amount {
+					t.Errorf("line %d total = %d, want %d", n, line.Total.Amount, line.Net.Amount+line.Tax.Amount)
+				}
+			}
+		})
+	}
+}
+
+func TestInvoiceCalculateTotalsInvalid(t *testing.T) {
+	tests := []*InvoiceLine{
+		{Quantity: "1/3", UnitPrice: NewMoney(100, "INR"), TaxRate: "0"},
+		{Quantity: "1", UnitPrice: NewMoney(100, "INR"), TaxRate: "1e1"},
+		{Quantity: "2", UnitPrice: NewMoney(1<<62, "INR"), TaxRate: "0"},
+	}
+
+	for _, line := range tests {
+		invoice := &Invoice{Currency: "INR", TaxTreatment: TaxStandard, Lines: []*InvoiceLine{line}}
+		if err := invoice.CalculateTotals(); err == nil {
+			t.Errorf("CalculateTotals() with quantity %q and rate %q succeeded, want an error", line.Quantity, line.TaxRate)
+		}
+	}
+}
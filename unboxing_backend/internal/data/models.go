@@ -13,21 +13,39 @@ var (
 )
 
 type Models struct {
-	Users       UserModel
-	Customers   CustomerModel
-	Payroll     PayrollModel
-	Billing     BillingModel
-	Token       TokenModel
-	Permissions PermissionModel
+	Users         UserModel
+	Customers     CustomerModel
+	Payroll       PayrollModel
+	Billing       BillingModel
+	Invoices      InvoiceModel
+	Payments      PaymentModel
+	CreditNotes   CreditNoteModel
+	Token         TokenModel
+	Permissions   PermissionModel
+	Roles         RoleModel
+	SetupKeys     SetupKeyModel
+	Rates         ExchangeRateModel
+	Reports       ReportModel
+	Taxes         TaxRateModel
+	Subscriptions SubscriptionModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Users:       UserModel{DB: db},
-		Customers:   CustomerModel{DB: db},
-		Payroll:     PayrollModel{DB: db},
-		Billing:     BillingModel{DB: db},
-		Token:       TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Users:         UserModel{DB: db},
+		Customers:     CustomerModel{DB: db},
+		Payroll:       PayrollModel{DB: db},
+		Billing:       BillingModel{DB: db},
+		Invoices:      InvoiceModel{DB: db},
+		Payments:      PaymentModel{DB: db},
+		CreditNotes:   CreditNoteModel{DB: db},
+		Token:         TokenModel{DB: db},
+		Permissions:   PermissionModel{DB: db},
+		Roles:         RoleModel{DB: db},
+		SetupKeys:     SetupKeyModel{DB: db},
+		Rates:         ExchangeRateModel{DB: db},
+		Reports:       ReportModel{DB: db},
+		Taxes:         TaxRateModel{DB: db},
+		Subscriptions: SubscriptionModel{DB: db},
 	}
 }
@@ -0,0 +1,244 @@
+package data
+
+import (
+	"company/internal/validator"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrCurrencyMismatch = errors.New("currencies do not match")
+	ErrAmountOverflow   = errors.New("amount out of range")
+)
+
+// currencyExponents holds the number of digits after the decimal separator of the
+// supported ISO-4217 currencies, i.e. how many minor units make up one major unit.
+var currencyExponents = map[string]int{
+	"AED": 2, "AUD": 2, "BDT": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2,
+	"DKK": 2, "EUR": 2, "GBP": 2, "HKD": 2, "IDR": 2, "INR": 2, "JOD": 3, "JPY": 0,
+	"KRW": 0, "KWD": 3, "LKR": 2, "MYR": 2, "NOK": 2, "NPR": 2, "NZD": 2, "OMR": 3,
+	"PHP": 2, "PKR": 2, "QAR": 2, "SAR": 2, "SEK": 2, "SGD": 2, "THB": 2, "TND": 3,
+	"USD": 2, "VND": 0, "ZAR": 2,
+}
+
+// ValidCurrency reports whether the ISO-4217 currency code is supported.
+func ValidCurrency(currency string) bool {
+	_, ok := currencyExponents[currency]
+	return ok
+}
+
+// Money is an exact amount of a currency, counted in the currency's minor units
+// (paise, cents...) so that sums never suffer from floating point rounding.
+//
+// It is stored in two columns: the amount in minor units, written and read by Value
+// and Scan, and the currency code, which has to be scanned into Currency separately.
+type Money struct {
+	Amount   int64  // Amount in minor units of the currency
+	Currency string // ISO-4217 currency code
+}
+
+// NewMoney returns an amount of the currency given in minor units.
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ParseMoney parses a decimal amount in major units, such as "1234.50", in the
+// currency. Amounts with more decimals than the currency has minor units are
+// rejected rather than rounded.
+func ParseMoney(amount, currency string) (Money, error) {
+	exponent, ok := currencyExponents[currency]
+	if !ok {
+		return Money{}, ErrUnknownCurrency
+	}
+
+	negative := strings.HasPrefix(amount, "-")
+	amount = strings.TrimPrefix(amount, "-")
+	whole, fraction, _ := strings.Cut(amount, ".")
+	if whole == "" || len(fraction) > exponent || strings.HasSuffix(amount, ".") {
+		return Money{}, ErrInvalidAmount
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	for _, r := range whole + fraction {
+		if r < '0' || r > '9' {
+			return Money{}, ErrInvalidAmount
+		}
+	}
+	minor, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, ErrAmountOverflow
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+// Decimal formats the amount in major units with all the decimals of the currency,
+// e.g. "1234.50".
+func (m Money) Decimal() string {
+	exponent := currencyExponents[m.Currency]
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+	}
+	digits := strconv.FormatUint(absolute(amount), 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// String formats the amount followed by its currency, e.g. "1234.50 INR".
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// IsZero reports whether the amount is zero.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsNegative reports whether the amount is below zero.
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Add returns the sum of two amounts of the same currency.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	sum := m.Amount + other.Amount
+	if (other.Amount > 0 && sum < m.Amount) || (other.Amount < 0 && sum > m.Amount) {
+		return Money{}, ErrAmountOverflow
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// Sub returns the difference of two amounts of the same currency.
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Amount == math.MinInt64 {
+		return Money{}, ErrAmountOverflow
+	}
+	return m.Add(other.Neg())
+}
+
+// Neg returns the amount with its sign flipped.
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Mul returns the amount multiplied by a whole quantity.
+func (m Money) Mul(quantity int64) (Money, error) {
+	product := m.Amount * quantity
+	if quantity != 0 && (product/quantity != m.Amount || (quantity == -1 && m.Amount == math.MinInt64)) {
+		return Money{}, ErrAmountOverflow
+	}
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
+
+// MulRat returns the amount multiplied by a decimal factor, such as a fractional
+// quantity or a tax rate, rounded half away from zero to whole minor units.
+func (m Money) MulRat(factor *big.Rat) (Money, error) {
+	amount, err := roundRat(new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), factor))
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: amount, Currency: m.Currency}, nil
+}
+
+// Sum adds up amounts of the given currency, which is also the currency of the
+// result when there are no amounts at all.
+func Sum(currency string, amounts ...Money) (Money, error) {
+	total := Money{Currency: currency}
+	for _, amount := range amounts {
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// MarshalJSON encodes the money as {"amount": "1234.50", "currency": "INR"}. The
+// amount is a decimal string so that clients don't lose precision by parsing it as a
+// floating point number.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{m.Decimal(), m.Currency})
+}
+
+// UnmarshalJSON decodes money written as {"amount": "1234.50", "currency": "INR"}.
+// The amount may also be a JSON number, which is read as decimal text and never
+// goes through a float.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var input struct {
+		Amount   json.RawMessage `json:"amount"`
+		Currency string          `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &input); err != nil {
+		return err
+	}
+
+	amount := string(input.Amount)
+	if unquoted, err := strconv.Unquote(amount); err == nil {
+		amount = unquoted
+	}
+	money, err := ParseMoney(amount, input.Currency)
+	if err != nil {
+		return fmt.Errorf("invalid money %s: %w", data, err)
+	}
+	*m = money
+	return nil
+}
+
+// Value stores the amount in minor units. The currency goes in its own column.
+func (m Money) Value() (driver.Value, error) {
+	return m.Amount, nil
+}
+
+// Scan reads an amount in minor units, leaving the currency untouched.
+func (m *Money) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case int64:
+		m.Amount = src
+	case []byte:
+		amount, err := strconv.ParseInt(string(src), 10, 64)
+		if err != nil {
+			return err
+		}
+		m.Amount = amount
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
+
+// ValidateMoney checks that the amount has a supported currency and is strictly
+// positive.
+func ValidateMoney(v *validator.Validator, key string, m Money) {
+	v.Check(ValidCurrency(m.Currency), key, "must have a supported ISO-4217 currency")
+	v.Check(m.Amount > 0, key, "must be greater than zero")
+}
+
+func absolute(n int64) uint64 {
+	if n < 0 {
+		return uint64(-(n + 1)) + 1
+	}
+	return uint64(n)
+}
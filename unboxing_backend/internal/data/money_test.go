@@ -0,0 +1,166 @@
+package data
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   string
+		currency string
+		want     int64
+		err      error
+	}{
+		{"whole", "1234", "INR", 123400, nil},
+		{"decimals", "1234.50", "INR", 123450, nil},
+		{"fewer decimals", "1234.5", "INR", 123450, nil},
+		{"negative", "-0.05", "USD", -5, nil},
+		{"three decimal currency", "1.234", "KWD", 1234, nil},
+		{"no minor units", "500", "JPY", 500, nil},
+		{"decimals without minor units", "500.0", "JPY", 0, ErrInvalidAmount},
+		{"too many decimals", "1.005", "INR", 0, ErrInvalidAmount},
+		{"trailing point", "12.", "INR", 0, ErrInvalidAmount},
+		{"no whole part", ".50", "INR", 0, ErrInvalidAmount},
+		{"empty", "", "INR", 0, ErrInvalidAmount},
+		{"exponent", "1e5", "INR", 0, ErrInvalidAmount},
+		{"plus sign", "+1", "INR", 0, ErrInvalidAmount},
+		{"double sign", "--1", "INR", 0, ErrInvalidAmount},
+		{"unknown currency", "1", "XYZ", 0, ErrUnknownCurrency},
+		{"largest", "92233720368547758.07", "INR", math.MaxInt64, nil},
+		{"overflow", "92233720368547758.08", "INR", 0, ErrAmountOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMoney(tt.amount, tt.currency)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseMoney(%q, %q) error = %v, want %v", tt.amount, tt.currency, err, tt.err)
+			}
+			if err == nil && (got.Amount != tt.want || got.Currency != tt.currency) {
+				t.Errorf("ParseMoney(%q, %q) = %v, want %d %s", tt.amount, tt.currency, got, tt.want, tt.currency)
+			}
+		})
+	}
+}
+
+func TestMoneyDecimal(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{NewMoney(123450, "INR"), "1234.50"},
+		{NewMoney(5, "INR"), "0.05"},
+		{NewMoney(-5, "INR"), "-0.05"},
+		{NewMoney(0, "INR"), "0.00"},
+		{NewMoney(1234, "KWD"), "1.234"},
+		{NewMoney(500, "JPY"), "500"},
+		{NewMoney(math.MinInt64, "INR"), "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.Decimal(); got != tt.want {
+			t.Errorf("Money{%d, %s}.Decimal() = %q, want %q", tt.money.Amount, tt.money.Currency, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func() (Money, error)
+		want int64
+		err  error
+	}{
+		{"add", func() (Money, error) { return NewMoney(150, "INR").Add(NewMoney(250, "INR")) }, 400, nil},
+		{"add negative", func() (Money, error) { return NewMoney(150, "INR").Add(NewMoney(-250, "INR")) }, -100, nil},
+		{"add currency mismatch", func() (Money, error) { return NewMoney(1, "INR").Add(NewMoney(1, "USD")) }, 0, ErrCurrencyMismatch},
+		{"add overflow", func() (Money, error) { return NewMoney(math.MaxInt64, "INR").Add(NewMoney(1, "INR")) }, 0, ErrAmountOverflow},
+		{"add underflow", func() (Money, error) { return NewMoney(math.MinInt64, "INR").Add(NewMoney(-1, "INR")) }, 0, ErrAmountOverflow},
+		{"sub", func() (Money, error) { return NewMoney(100, "INR").Sub(NewMoney(250, "INR")) }, -150, nil},
+		{"sub smallest", func() (Money, error) { return NewMoney(0, "INR").Sub(NewMoney(math.MinInt64, "INR")) }, 0, ErrAmountOverflow},
+		{"sub underflow", func() (Money, error) { return NewMoney(math.MinInt64, "INR").Sub(NewMoney(1, "INR")) }, 0, ErrAmountOverflow},
+		{"mul", func() (Money, error) { return NewMoney(250, "INR").Mul(3) }, 750, nil},
+		{"mul zero", func() (Money, error) { return NewMoney(math.MaxInt64, "INR").Mul(0) }, 0, nil},
+		{"mul overflow", func() (Money, error) { return NewMoney(math.MaxInt64/2+1, "INR").Mul(2) }, 0, ErrAmountOverflow},
+		{"mul negate smallest", func() (Money, error) { return NewMoney(math.MinInt64, "INR").Mul(-1) }, 0, ErrAmountOverflow},
+		{"sum", func() (Money, error) { return Sum("INR", NewMoney(1, "INR"), NewMoney(2, "INR"), NewMoney(3, "INR")) }, 6, nil},
+		{"sum mismatch", func() (Money, error) { return Sum("INR", NewMoney(1, "USD")) }, 0, ErrCurrencyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if err == nil && got.Amount != tt.want {
+				t.Errorf("amount = %d, want %d", got.Amount, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyMulRat(t *testing.T) {
+	tests := []struct {
+		amount int64
+		factor *big.Rat
+		want   int64
+		err    error
+	}{
+		{1000, big.NewRat(18, 100), 180, nil},
+		{5, big.NewRat(1, 2), 3, nil},   // 2.5 rounds away from zero
+		{-5, big.NewRat(1, 2), -3, nil}, // and so does -2.5
+		{7, big.NewRat(1, 3), 2, nil},
+		{8, big.NewRat(1, 3), 3, nil},
+		{math.MaxInt64, big.NewRat(2, 1), 0, ErrAmountOverflow},
+	}
+
+	for _, tt := range tests {
+		got, err := NewMoney(tt.amount, "INR").MulRat(tt.factor)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("MulRat(%d, %s) error = %v, want %v", tt.amount, tt.factor, err, tt.err)
+			continue
+		}
+		if err == nil && got.Amount != tt.want {
+			t.Errorf("MulRat(%d, %s) = %d, want %d", tt.amount, tt.factor, got.Amount, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Money
+		ok    bool
+	}{
+		{`{"amount": "1234.50", "currency": "INR"}`, NewMoney(123450, "INR"), true},
+		{`{"amount": 1234.5, "currency": "INR"}`, NewMoney(123450, "INR"), true},
+		{`{"amount": 1e3, "currency": "INR"}`, Money{}, false},
+		{`{"amount": "1.005", "currency": "INR"}`, Money{}, false},
+		{`{"amount": "1", "currency": "XYZ"}`, Money{}, false},
+	}
+
+	for _, tt := range tests {
+		var got Money
+		err := got.UnmarshalJSON([]byte(tt.input))
+		if (err == nil) != tt.ok {
+			t.Errorf("UnmarshalJSON(%s) error = %v, want ok %t", tt.input, err, tt.ok)
+			continue
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("UnmarshalJSON(%s) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+
+	encoded, err := NewMoney(123450, "INR").MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"amount":"1234.50","currency":"INR"}`; string(encoded) != want {
+		t.Errorf("MarshalJSON() = %s, want %s", encoded, want)
+	}
+}
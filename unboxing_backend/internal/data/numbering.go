@@ -0,0 +1,85 @@
+package data
+
+import (
+	"company/internal/validator"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+)
+
+// SeriesRX matches the names of numbering series, such as "INV" or "EXP".
+var SeriesRX = regexp.MustCompile(`^[A-Z][A-Z0-9]{0,9}$`)
+
+func ValidateSeries(v *validator.Validator, series string) {
+	v.Check(validator.Matches(series, SeriesRX), "series", "must be 1 to 10 capital letters or digits, starting with a letter")
+}
+
+// Numbering allocates legally compliant document numbers, such as INV-2026-000123,
+// counting from 1 in each series and fiscal year. Numbers are allocated inside the
+// transaction saving the document, so they are gap-free: a failed save gives its
+// number back, and concurrent saves in the same series wait for each other. Numbers
+// of voided documents are never reused.
+type Numbering struct {
+	// InvoiceSeries is the series of invoices created without one, INV when unset.
+	InvoiceSeries string
+	// CreditNoteSeries is the series of credit notes, CN when unset.
+	CreditNoteSeries string
+	// FiscalYearStart is the first month of the fiscal year, January when unset.
+	FiscalYearStart time.Month
+}
+
+// DefaultInvoiceSeries returns the series of invoices created without one.
+func (n Numbering) DefaultInvoiceSeries() string {
+	if n.InvoiceSeries == "" {
+		return "INV"
+	}
+	return n.InvoiceSeries
+}
+
+// DefaultCreditNoteSeries returns the series credit notes are numbered in.
+func (n Numbering) DefaultCreditNoteSeries() string {
+	if n.CreditNoteSeries == "" {
+		return "CN"
+	}
+	return n.CreditNoteSeries
+}
+
+// ValidateInvoiceSeries checks the series can number invoices. The series of credit
+// notes is reserved to them, as sharing the counter of a series would leave gaps in
+// the numbers of both.
+func (n Numbering) ValidateInvoiceSeries(v *validator.Validator, series string) {
+	ValidateSeries(v, series)
+	v.Check(series != n.DefaultCreditNoteSeries(), "series", "is reserved to credit notes")
+}
+
+// FiscalYear returns the fiscal year of the date, named after the calendar year it
+// starts in.
+func (n Numbering) FiscalYear(date time.Time) int {
+	if n.FiscalYearStart > time.January && date.Month() < n.FiscalYearStart {
+		return date.Year() - 1
+	}
+	return date.Year()
+}
+
+// Next allocates the next number of the series in the fiscal year of the date, as
+// part of the transaction.
+func (n Numbering) Next(ctx context.Context, tx *sql.Tx, series string, date time.Time) (string, error) {
+	query := `
+	INSERT INTO number_series (series, fiscal_year, last_number)
+	VALUES ($1, $2, 1)
+	ON CONFLICT (series, fiscal_year) DO UPDATE SET last_number = number_series.last_number + 1
+	RETURNING last_number
+	`
+
+	fiscalYear := n.FiscalYear(date)
+	var number int64
+	err := tx.QueryRowContext(ctx, query, series, fiscalYear).Scan(&number)
+	if err != nil {
+		log.Println("Allocating document number", err)
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d-%06d", series, fiscalYear, number), nil
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"company/internal/validator"
+	"testing"
+	"time"
+)
+
+func TestNumberingFiscalYear(t *testing.T) {
+	tests := []struct {
+		name  string
+		start time.Month
+		date  string
+		want  int
+	}{
+		{"calendar year by default", 0, "2026-01-01", 2026},
+		{"calendar year end", time.January, "2026-12-31", 2026},
+		{"April start, before it", time.April, "2026-03-31", 2025},
+		{"April start, on it", time.April, "2026-04-01", 2026},
+		{"April start, year end", time.April, "2026-12-31", 2026},
+		{"April start, January", time.April, "2027-01-01", 2026},
+		{"July start, June", time.July, "2026-06-30", 2025},
+		{"December start, November", time.December, "2026-11-30", 2025},
+		{"December start, on it", time.December, "2026-12-01", 2026},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			date, err := time.Parse(time.DateOnly, tt.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := Numbering{FiscalYearStart: tt.start}
+			if got := n.FiscalYear(date); got != tt.want {
+				t.Errorf("FiscalYear(%s) = %d, want %d", tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumberingValidateInvoiceSeries(t *testing.T) {
+	tests := []struct {
+		numbering Numbering
+		series    string
+		valid     bool
+	}{
+		{Numbering{}, "INV", true},
+		{Numbering{}, "EXP2", true},
+		{Numbering{}, "CN", false},
+		{Numbering{CreditNoteSeries: "CRN"}, "CN", true},
+		{Numbering{CreditNoteSeries: "CRN"}, "CRN", false},
+		{Numbering{}, "inv", false},
+		{Numbering{}, "2INV", false},
+		{Numbering{}, "INVOICES123", false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		tt.numbering.ValidateInvoiceSeries(v, tt.series)
+		if v.Valid() != tt.valid {
+			t.Errorf("ValidateInvoiceSeries(%q) with credit notes in %q valid = %t, want %t", tt.series, tt.numbering.DefaultCreditNoteSeries(), v.Valid(), tt.valid)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"company/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// PaymentMethods lists the accepted ways customers pay their invoices.
+func PaymentMethods() []string {
+	return []string{"bank_transfer", "card", "cash", "cheque", "upi", "other"}
+}
+
+// Payment is money received from a customer against an invoice.
+type Payment struct {
+	ID        int64     `json:"id"`
+	InvoiceID int64     `json:"invoice_id"`
+	Amount    Money     `json:"amount"`
+	PaidOn    time.Time `json:"paid_on"`
+	Method    string    `json:"method"`
+	Reference string    `json:"reference"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ValidatePayment checks the payment can be recorded against the invoice, which it
+// must not overpay.
+func ValidatePayment(v *validator.Validator, payment *Payment, invoice *Invoice) {
+	ValidateMoney(v, "amount", payment.Amount)
+	v.Check(payment.Amount.Currency == invoice.Currency, "amount", "must be in the currency of the invoice")
+	v.Check(payment.Amount.Amount <= invoice.Balance.Amount, "amount", "must not be more than the balance of the invoice")
+	v.Check(!payment.PaidOn.IsZero(), "paid_on", "must be provided")
+	v.Check(!payment.PaidOn.Before(invoice.IssueDate.Truncate(24*time.Hour)), "paid_on", "must not be before the issue date of the invoice")
+	v.Check(validator.In(payment.Method, PaymentMethods()...), "method", "invalid payment method")
+	v.Check(len(payment.Reference) <= 200, "reference", "must not be more than 200 bytes long")
+}
+
+type PaymentModel struct {
+	DB *sql.DB
+}
+
+// Insert records the payment and moves the invoice to partially paid or paid, unless
+// the invoice was changed since it was read.
+func (m PaymentModel) Insert(payment *Payment, invoice *Invoice) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE invoices
+	SET amount_paid = amount_paid + $1,
+		status = CASE WHEN amount_paid + amount_credited + $1 = total THEN 'paid' ELSE 'partially_paid' END,
+		version = version + 1
+	WHERE id = $2 AND version = $3
+	AND status IN ('issued', 'partially_paid') AND amount_paid + amount_credited + $1 <= total
+	RETURNING invoice_status(status, due_date), amount_paid, version
+	`
+	args := []interface{}{payment.Amount, invoice.ID, invoice.Version}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&invoice.Status, &invoice.AmountPaid, &invoice.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			log.Println("Recording payment on invoice", err)
+			return err
+		}
+	}
+	invoice.setBalance()
+
+	query = `
+	INSERT INTO payments (invoice_id, amount, currency, paid_on, method, reference)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
+	`
+	payment.InvoiceID = invoice.ID
+	args = []interface{}{payment.InvoiceID, payment.Amount, payment.Amount.Currency, payment.PaidOn, payment.Method, payment.Reference}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&payment.ID, &payment.CreatedAt)
+	if err != nil {
+		log.Println("Creating payment in the database", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Payment of %s recorded on invoice %s\n", payment.Amount, invoice.Number)
+	return nil
+}
+
+// GetAllForInvoice fetches the payments of an invoice, oldest first.
+func (m PaymentModel) GetAllForInvoice(invoiceID int64) ([]*Payment, error) {
+	query := `
+	SELECT id, invoice_id, amount, currency, paid_on, method, reference, created_at
+	FROM payments
+	WHERE invoice_id = $1
+	ORDER BY paid_on, id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, invoiceID)
+	if err != nil {
+		log.Println("Error getting payments", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*Payment{}
+	for rows.Next() {
+		var payment Payment
+
+		err = rows.Scan(
+			&payment.ID,
+			&payment.InvoiceID,
+			&payment.Amount,
+			&payment.Amount.Currency,
+			&payment.PaidOn,
+			&payment.Method,
+			&payment.Reference,
+			&payment.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, &payment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
@@ -4,37 +4,97 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 )
 
 type Payroll struct {
-	ID        int64     `json:"id"`        // Unique integer ID for each payroll entry
-	EmployeeID int64    `json:"employee_id"`// Employee ID to whom the payroll belongs
-	Amount    float64   `json:"amount"`    // Payroll amount
-	Date      time.Time `json:"date"`      // Payroll date
-	Version   int32     `json:"version"`   // Version number for optimistic locking
+	ID         int64     `json:"id"`          // Unique integer ID for each payroll entry
+	EmployeeID int64     `json:"employee_id"` // Employee ID to whom the payroll belongs
+	Amount     Money     `json:"amount"`      // Payroll amount and currency
+	Date       time.Time `json:"date"`        // Payroll date
+	Version    int32     `json:"version"`     // Version number for optimistic locking
 }
 
 type PayrollModel struct {
 	DB *sql.DB
 }
 
-// GetAll fetches all payroll entries from the database.
-func (m PayrollModel) GetAll() ([]*Payroll, error) {
+// GetAll fetches a page of payroll entries from the database, optionally only those
+// of a single employee (employeeID 0 means every employee).
+func (m PayrollModel) GetAll(employeeID int64, filters Filters) ([]*Payroll, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, employee_id, amount, currency, date, version
+	FROM payroll
+	WHERE ($1::bigint = 0 OR employee_id = $1)
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	args := []interface{}{employeeID, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println("Error getting payroll entries", err)
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	payrolls := []*Payroll{}
+
+	for rows.Next() {
+		var payroll Payroll
+
+		err = rows.Scan(
+			&totalRecords,
+			&payroll.ID,
+			&payroll.EmployeeID,
+			&payroll.Amount,
+			&payroll.Amount.Currency,
+			&payroll.Date,
+			&payroll.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		payrolls = append(payrolls, &payroll)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return payrolls, metadata, nil
+}
+
+// GetAllAfter fetches the payroll entries following the cursor position in (date, id)
+// order, for ledgers too long to page through with offsets. The returned cursor
+// points at the last entry and is nil when there are no more entries.
+func (m PayrollModel) GetAllAfter(employeeID int64, filters CursorFilters) ([]*Payroll, *Cursor, error) {
 	query := `
-	SELECT id, employee_id, amount, date, version
+	SELECT id, employee_id, amount, currency, date, version
 	FROM payroll
-	ORDER BY id
+	WHERE ($1::bigint = 0 OR employee_id = $1)
+	AND (date, id) > ($2, $3)
+	ORDER BY date ASC, id ASC
+	LIMIT $4
 	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	afterDate, afterID := filters.args()
+	// Ask for one entry more than the limit to find out whether there is a next page.
+	args := []interface{}{employeeID, afterDate, afterID, filters.Limit + 1}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		log.Println("Error getting payroll entries", err)
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
@@ -47,31 +107,38 @@ func (m PayrollModel) GetAll() ([]*Payroll, error) {
 			&payroll.ID,
 			&payroll.EmployeeID,
 			&payroll.Amount,
+			&payroll.Amount.Currency,
 			&payroll.Date,
 			&payroll.Version,
 		)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		payrolls = append(payrolls, &payroll)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return payrolls, nil
+	var next *Cursor
+	if len(payrolls) > filters.Limit {
+		payrolls = payrolls[:filters.Limit]
+		last := payrolls[len(payrolls)-1]
+		next = &Cursor{Date: last.Date, ID: last.ID}
+	}
+	return payrolls, next, nil
 }
 
 // Insert adds a new payroll entry to the database.
 func (m PayrollModel) Insert(payroll *Payroll) error {
 	query := `
-	INSERT INTO payroll (employee_id, amount, date)
-	VALUES ($1, $2, $3)
+	INSERT INTO payroll (employee_id, amount, currency, date)
+	VALUES ($1, $2, $3, $4)
 	RETURNING id, version
 	`
 
-	err := m.DB.QueryRow(query, payroll.EmployeeID, payroll.Amount, payroll.Date).Scan(&payroll.ID, &payroll.Version)
+	err := m.DB.QueryRow(query, payroll.EmployeeID, payroll.Amount, payroll.Amount.Currency, payroll.Date).Scan(&payroll.ID, &payroll.Version)
 	if err != nil {
 		log.Println("Creating payroll entry in the database", err)
 	} else {
@@ -86,7 +153,7 @@ func (m PayrollModel) Get(id int64) (*Payroll, error) {
 	defer cancel()
 
 	query := `
-	SELECT id, employee_id, amount, date, version 
+	SELECT id, employee_id, amount, currency, date, version 
 	FROM payroll
 	WHERE id = $1
 	`
@@ -96,6 +163,7 @@ func (m PayrollModel) Get(id int64) (*Payroll, error) {
 		&payroll.ID,
 		&payroll.EmployeeID,
 		&payroll.Amount,
+		&payroll.Amount.Currency,
 		&payroll.Date,
 		&payroll.Version,
 	)
@@ -115,12 +183,12 @@ func (m PayrollModel) Get(id int64) (*Payroll, error) {
 func (m PayrollModel) Update(payroll *Payroll) error {
 	query := `
 	UPDATE payroll
-	SET employee_id = $1, amount = $2, date = $3, version = version + 1
-	WHERE id = $4 AND version = $5
+	SET employee_id = $1, amount = $2, currency = $3, date = $4, version = version + 1
+	WHERE id = $5 AND version = $6
 	RETURNING version
 	`
 
-	err := m.DB.QueryRow(query, payroll.EmployeeID, payroll.Amount, payroll.Date, payroll.ID, payroll.Version).Scan(&payroll.Version)
+	err := m.DB.QueryRow(query, payroll.EmployeeID, payroll.Amount, payroll.Amount.Currency, payroll.Date, payroll.ID, payroll.Version).Scan(&payroll.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -0,0 +1,108 @@
+package data
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PermissionCache keeps the permissions of each role in memory, so that checking a
+// permission doesn't need a trip to the database on every request. Entries expire
+// after the TTL, and are dropped straight away whenever the permissions of a role
+// are changed through the PermissionModel. Expired entries are removed when they are
+// read, and swept at most once per TTL when new ones are added, so that keys which
+// are never read again don't pile up.
+type PermissionCache struct {
+	ttl       time.Duration
+	mu        sync.RWMutex
+	entries   map[string]permissionCacheEntry
+	nextSweep time.Time
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+}
+
+type permissionCacheEntry struct {
+	permissions Permissions
+	expiry      time.Time
+}
+
+// PermissionCacheStats is a snapshot of the cache counters.
+type PermissionCacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+func NewPermissionCache(ttl time.Duration) *PermissionCache {
+	return &PermissionCache{
+		ttl:       ttl,
+		entries:   make(map[string]permissionCacheEntry),
+		nextSweep: time.Now().Add(ttl),
+	}
+}
+
+// Get returns the cached permissions for the key, counting a hit or a miss.
+func (c *PermissionCache) Get(key string) (Permissions, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	if now := time.Now(); now.After(entry.expiry) {
+		c.mu.Lock()
+		// The entry may have been set again since it was read
+		if entry, ok := c.entries[key]; ok && now.After(entry.expiry) {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return entry.permissions, true
+}
+
+func (c *PermissionCache) Set(key string, permissions Permissions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.After(c.nextSweep) {
+		for k, entry := range c.entries {
+			if now.After(entry.expiry) {
+				delete(c.entries, k)
+			}
+		}
+		c.nextSweep = now.Add(c.ttl)
+	}
+	c.entries[key] = permissionCacheEntry{
+		permissions: permissions,
+		expiry:      now.Add(c.ttl),
+	}
+}
+
+// Invalidate drops the cached permissions for a single key.
+func (c *PermissionCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// InvalidateAll empties the cache, for changes that may affect any key.
+func (c *PermissionCache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]permissionCacheEntry)
+}
+
+func (c *PermissionCache) Stats() PermissionCacheStats {
+	c.mu.RLock()
+	entries := len(c.entries)
+	c.mu.RUnlock()
+	return PermissionCacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+	}
+}
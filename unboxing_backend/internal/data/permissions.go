@@ -3,11 +3,28 @@ package data
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Permissions []string
 
+// Effects of a per-user permission override.
+const (
+	PermissionGrant = "grant"
+	PermissionDeny  = "deny"
+)
+
+// UserPermissions describes the permissions of a single user: the one-off grants and
+// denies set for them, and the resulting effective permissions.
+type UserPermissions struct {
+	Grants    Permissions `json:"grants"`
+	Denies    Permissions `json:"denies"`
+	Effective Permissions `json:"effective"`
+}
+
 // Add a helper method to check whether the Permissions slice contains a specific permission code.
 func (p Permissions) Include(code string) bool {
 	for i := range p {
@@ -19,11 +36,191 @@ func (p Permissions) Include(code string) bool {
 }
 
 type PermissionModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *PermissionCache // Optional, when nil every lookup goes to the database
 }
 
-// The GetAllForRole() method returns all permission codes for a role
+// The GetAllForRole() method returns all permission codes for a role, from the cache
+// when possible.
 func (m PermissionModel) GetAllForRole(roleName string) (Permissions, error) {
+	if m.Cache != nil {
+		if permissions, ok := m.Cache.Get(rolePermissionsCacheKey(roleName)); ok {
+			return permissions, nil
+		}
+	}
+	permissions, err := m.getAllForRole(roleName)
+	if err != nil {
+		return nil, err
+	}
+	if m.Cache != nil {
+		m.Cache.Set(rolePermissionsCacheKey(roleName), permissions)
+	}
+	return permissions, nil
+}
+
+// The GetAllForRoles() method returns the union of the permission codes of all the
+// given roles, each role being looked up through the cache.
+func (m PermissionModel) GetAllForRoles(roleNames []string) (Permissions, error) {
+	permissions := Permissions{}
+	for _, roleName := range roleNames {
+		rolePermissions, err := m.GetAllForRole(roleName)
+		if err != nil {
+			return nil, err
+		}
+		for _, code := range rolePermissions {
+			if !permissions.Include(code) {
+				permissions = append(permissions, code)
+			}
+		}
+	}
+	return permissions, nil
+}
+
+// The GetAllForUser() method returns the effective permission codes of a user: the
+// union of the permissions of all of their roles and their one-off grants, minus
+// their explicit denies. A deny always wins over a grant.
+func (m PermissionModel) GetAllForUser(user *User) (Permissions, error) {
+	userPermissions, err := m.GetOverridesForUser(user)
+	if err != nil {
+		return nil, err
+	}
+	return userPermissions.Effective, nil
+}
+
+// The GetOverridesForUser() method returns the one-off grants and denies of a user
+// together with their effective permissions.
+func (m PermissionModel) GetOverridesForUser(user *User) (*UserPermissions, error) {
+	rolePermissions, err := m.GetAllForRoles(user.Roles)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := m.getForUser(user.ID, PermissionGrant)
+	if err != nil {
+		return nil, err
+	}
+	denies, err := m.getForUser(user.ID, PermissionDeny)
+	if err != nil {
+		return nil, err
+	}
+
+	effective := Permissions{}
+	for _, code := range append(rolePermissions, grants...) {
+		if !denies.Include(code) && !effective.Include(code) {
+			effective = append(effective, code)
+		}
+	}
+	return &UserPermissions{Grants: grants, Denies: denies, Effective: effective}, nil
+}
+
+// getForUser() returns the codes overridden for the user with the given effect. The
+// overrides are cached like role permissions, under a key of their own.
+func (m PermissionModel) getForUser(userID int64, effect string) (Permissions, error) {
+	key := userPermissionsCacheKey(userID, effect)
+	if m.Cache != nil {
+		if permissions, ok := m.Cache.Get(key); ok {
+			return permissions, nil
+		}
+	}
+	query := `
+	SELECT permissions.name
+	FROM permissions
+	INNER JOIN user_permissions ON user_permissions.permission_id = permissions.id
+	WHERE user_permissions.user_id = $1 AND user_permissions.effect = $2
+	ORDER BY permissions.name`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, effect)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	permissions := Permissions{}
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if m.Cache != nil {
+		m.Cache.Set(key, permissions)
+	}
+	return permissions, nil
+}
+
+// The SetForUser() method grants or denies a permission code to a single user,
+// replacing any previous override of the same code.
+func (m PermissionModel) SetForUser(userID int64, code, effect string) error {
+	query := `
+	INSERT INTO user_permissions (user_id, permission_id, effect)
+	SELECT $1, permissions.id, $3
+	FROM permissions
+	WHERE permissions.name = $2
+	ON CONFLICT (user_id, permission_id) DO UPDATE SET effect = EXCLUDED.effect`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, code, effect)
+	if err != nil {
+		return err
+	}
+	m.invalidateUser(userID)
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// The RemoveForUser() method drops the override of a permission code for a user, so
+// that only their roles decide whether they have it.
+func (m PermissionModel) RemoveForUser(userID int64, code string) error {
+	query := `
+	DELETE FROM user_permissions
+	USING permissions
+	WHERE user_permissions.permission_id = permissions.id
+	AND user_permissions.user_id = $1 AND permissions.name = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, code)
+	if err != nil {
+		return err
+	}
+	m.invalidateUser(userID)
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m PermissionModel) invalidateUser(userID int64) {
+	if m.Cache != nil {
+		m.Cache.Invalidate(userPermissionsCacheKey(userID, PermissionGrant))
+		m.Cache.Invalidate(userPermissionsCacheKey(userID, PermissionDeny))
+	}
+}
+
+// Role permissions and user overrides share the cache, the keys are prefixed so that
+// they can't clash.
+func rolePermissionsCacheKey(roleName string) string {
+	return "role:" + roleName
+}
+
+func userPermissionsCacheKey(userID int64, effect string) string {
+	return fmt.Sprintf("user:%d:%s", userID, effect)
+}
+
+func (m PermissionModel) getAllForRole(roleName string) (Permissions, error) {
 	query := `
 	WITH role_id AS (
 	    SELECT id
@@ -56,3 +253,80 @@ func (m PermissionModel) GetAllForRole(roleName string) (Permissions, error) {
 	}
 	return permissions, nil
 }
+
+// The GetAll() method returns the catalogue of permission codes. Codes are checked by
+// the handlers, so the catalogue is only ever extended through migrations.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+	SELECT name
+	FROM permissions
+	ORDER BY name`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	permissions := Permissions{}
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// The AddForRole() method grants the permission codes to a role. Codes the role
+// already has are left alone.
+func (m PermissionModel) AddForRole(roleName string, codes ...string) error {
+	query := `
+	INSERT INTO role_permissions (role_id, permission_id)
+	SELECT roles.id, permissions.id
+	FROM roles, permissions
+	WHERE roles.name = $1 AND permissions.name = ANY($2)
+	ON CONFLICT DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, roleName, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+	if m.Cache != nil {
+		m.Cache.Invalidate(rolePermissionsCacheKey(roleName))
+	}
+	return nil
+}
+
+// The RemoveForRole() method revokes a permission code from a role.
+func (m PermissionModel) RemoveForRole(roleName string, code string) error {
+	query := `
+	DELETE FROM role_permissions
+	USING roles, permissions
+	WHERE role_permissions.role_id = roles.id
+	AND role_permissions.permission_id = permissions.id
+	AND roles.name = $1 AND permissions.name = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, roleName, code)
+	if err != nil {
+		return err
+	}
+	if m.Cache != nil {
+		m.Cache.Invalidate(rolePermissionsCacheKey(roleName))
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,408 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+)
+
+// billingReportGroups maps the ways billing totals can be grouped to the SQL giving
+// the key and the label of each group.
+var billingReportGroups = map[string][2]string{
+	"customer": {"customers.id::text", "customers.name"},
+	"month":    {"to_char(billing.date, 'YYYY-MM')", "to_char(billing.date, 'YYYY-MM')"},
+	"currency": {"billing.currency", "billing.currency"},
+}
+
+// BillingReportGroups lists the accepted values of the report grouping.
+func BillingReportGroups() []string {
+	return []string{"customer", "month", "currency"}
+}
+
+// BillingTotal is the total billed for one group of a report, both converted into
+// the base currency and in each of the currencies billed.
+type BillingTotal struct {
+	Key      string  `json:"key"`
+	Label    string  `json:"label"`
+	Billings int     `json:"billings"`
+	Total    Money   `json:"total"`
+	Original []Money `json:"original"`
+}
+
+// BillingReport holds the billing totals of a period converted into a single base
+// currency with the exchange rate effective on the date of each billing entry.
+type BillingReport struct {
+	BaseCurrency string          `json:"base_currency"`
+	From         time.Time       `json:"from"`
+	To           time.Time       `json:"to"`
+	GroupBy      string          `json:"group_by"`
+	Groups       []*BillingTotal `json:"groups"`
+	Total        Money           `json:"total"`
+}
+
+type ReportModel struct {
+	DB *sql.DB
+}
+
+// BillingTotals adds up the billing entries of the customers in the scope dated from
+// `from` up to, but excluding, `to`, leaving out drafts and voided invoices. It fails
+// with a MissingExchangeRateError when an entry is in a currency without a rate into
+// the base currency on its date.
+func (m ReportModel) BillingTotals(baseCurrency, groupBy string, from, to time.Time, scope CustomerScope) (*BillingReport, error) {
+	group, ok := billingReportGroups[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown billing report grouping %q", groupBy)
+	}
+	// Amounts are multiplied by their rate in NUMERIC so the sums stay exact, and
+	// only the total of each group and currency gets rounded.
+	query := fmt.Sprintf(`
+	SELECT %s, %s, billing.currency, count(*),
+		sum(billing.amount * CASE WHEN billing.currency = $1 THEN 1 ELSE rate.rate END)::text,
+		sum(billing.amount),
+		min(billing.date) FILTER (WHERE billing.currency <> $1 AND rate.rate IS NULL)
+	FROM billing
+	INNER JOIN customers ON customers.id = billing.customer_id
+	LEFT JOIN LATERAL (
+		SELECT exchange_rates.rate
+		FROM exchange_rates
+		WHERE exchange_rates.currency = billing.currency
+		AND exchange_rates.base_currency = $1
+		AND exchange_rates.effective_on <= billing.date::date
+		ORDER BY exchange_rates.effective_on DESC
+		LIMIT 1
+	) rate ON true
+	WHERE ($2::bigint = 0 OR customers.account_manager_id = $2)
+	AND billing.date >= $3 AND billing.date < $4
+	AND billing.status NOT IN ('draft', 'void')
+	GROUP BY 1, 2, 3
+	ORDER BY 2, 1, 3
+	`, group[0], group[1])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, baseCurrency, scope.AccountManagerID, from, to)
+	if err != nil {
+		log.Println("Error getting billing totals", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &BillingReport{
+		BaseCurrency: baseCurrency,
+		From:         from,
+		To:           to,
+		GroupBy:      groupBy,
+		Groups:       []*BillingTotal{},
+		Total:        Money{Currency: baseCurrency},
+	}
+	var current *BillingTotal
+
+	for rows.Next() {
+		var (
+			key, label, currency string
+			billings             int
+			converted            sql.NullString
+			original             int64
+			missingRateOn        sql.NullTime
+		)
+		err = rows.Scan(&key, &label, &currency, &billings, &converted, &original, &missingRateOn)
+		if err != nil {
+			return nil, err
+		}
+		if missingRateOn.Valid {
+			return nil, &MissingExchangeRateError{Currency: currency, BaseCurrency: baseCurrency, Date: missingRateOn.Time}
+		}
+
+		value, ok := new(big.Rat).SetString(converted.String)
+		if !ok {
+			return nil, fmt.Errorf("invalid converted amount %q", converted.String)
+		}
+		total, err := rescale(value, currency, baseCurrency)
+		if err != nil {
+			return nil, err
+		}
+
+		if current == nil || current.Key != key {
+			current = &BillingTotal{Key: key, Label: label, Total: Money{Currency: baseCurrency}, Original: []Money{}}
+			report.Groups = append(report.Groups, current)
+		}
+		current.Billings += billings
+		current.Original = append(current.Original, Money{Amount: original, Currency: currency})
+		if current.Total, err = current.Total.Add(total); err != nil {
+			return nil, err
+		}
+		if report.Total, err = report.Total.Add(total); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// TaxSummaryRow is the tax of the invoices of a period at one rate of one category,
+// for one currency and tax treatment, net of the credit notes of the period. Under
+// reverse charge the tax is the one due by the customers, which wasn't charged.
+type TaxSummaryRow struct {
+	Currency     string `json:"currency"`
+	TaxTreatment string `json:"tax_treatment"`
+	Category     string `json:"category,omitempty"`
+	Rate         string `json:"rate"`
+	Invoices     int    `json:"invoices"`
+	CreditNotes  int    `json:"credit_notes"`
+	Taxable      Money  `json:"taxable"`
+	Tax          Money  `json:"tax"`
+}
+
+// TaxSummary holds the tax of the invoices issued in a period, for accountants to
+// file tax returns with. TaxCharged is the tax charged in each currency.
+type TaxSummary struct {
+	From       time.Time        `json:"from"`
+	To         time.Time        `json:"to"`
+	Rows       []*TaxSummaryRow `json:"rows"`
+	TaxCharged []Money          `json:"tax_charged"`
+}
+
+// TaxSummary adds up the tax of the invoices of the customers in the scope issued
+// from `from` up to, but excluding, `to`, leaving out drafts and voided invoices, and
+// takes off it the tax of the credit notes issued in the same period.
+func (m ReportModel) TaxSummary(from, to time.Time, scope CustomerScope) (*TaxSummary, error) {
+	// Line amounts are rounded the way Invoice.CalculateTotals rounds them, so the
+	// summary adds up to the tax printed on the invoices.
+	query := `
+	SELECT currency, tax_treatment, category, rate, count(DISTINCT invoice_id), count(DISTINCT credit_note_id),
+		sum(taxable)::bigint, sum(tax)::bigint
+	FROM (
+		SELECT invoices.currency, invoices.tax_treatment, coalesce(invoice_lines.tax_category, '') AS category,
+			invoice_lines.tax_rate::text AS rate, invoices.id AS invoice_id, NULL::bigint AS credit_note_id,
+			round(invoice_lines.quantity * invoice_lines.unit_price) AS taxable,
+			round(round(invoice_lines.quantity * invoice_lines.unit_price) * invoice_lines.tax_rate / 100) AS tax
+		FROM invoices
+		INNER JOIN invoice_lines ON invoice_lines.invoice_id = invoices.id
+		INNER JOIN customers ON customers.id = invoices.customer_id
+		WHERE ($1::bigint = 0 OR customers.account_manager_id = $1)
+		AND invoices.issue_date >= $2 AND invoices.issue_date < $3
+		AND invoices.status NOT IN ('draft', 'void')
+		UNION ALL
+		SELECT invoices.currency, invoices.tax_treatment, credit_note_taxes.category,
+			credit_note_taxes.rate::text, NULL, credit_notes.id,
+			-credit_note_taxes.taxable, -credit_note_taxes.tax
+		FROM credit_note_taxes
+		INNER JOIN credit_notes ON credit_notes.id = credit_note_taxes.credit_note_id
+		INNER JOIN invoices ON invoices.id = credit_notes.invoice_id
+		INNER JOIN customers ON customers.id = invoices.customer_id
+		WHERE ($1::bigint = 0 OR customers.account_manager_id = $1)
+		AND credit_notes.issued_on >= $2::date AND credit_notes.issued_on < $3::date
+	) taxes
+	GROUP BY 1, 2, 3, 4
+	ORDER BY 1, 2, 3, 4
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, scope.AccountManagerID, from, to)
+	if err != nil {
+		log.Println("Error getting tax summary", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	summary := &TaxSummary{From: from, To: to, Rows: []*TaxSummaryRow{}, TaxCharged: []Money{}}
+	for rows.Next() {
+		var row TaxSummaryRow
+
+		err = rows.Scan(&row.Currency, &row.TaxTreatment, &row.Category, &row.Rate, &row.Invoices, &row.CreditNotes, &row.Taxable, &row.Tax)
+		if err != nil {
+			return nil, err
+		}
+		row.Rate = trimDecimal(row.Rate)
+		row.Taxable.Currency = row.Currency
+		row.Tax.Currency = row.Currency
+		summary.Rows = append(summary.Rows, &row)
+
+		if row.TaxTreatment == TaxReverseCharge {
+			continue
+		}
+		n := len(summary.TaxCharged)
+		if n == 0 || summary.TaxCharged[n-1].Currency != row.Currency {
+			summary.TaxCharged = append(summary.TaxCharged, Money{Currency: row.Currency})
+			n++
+		}
+		if summary.TaxCharged[n-1], err = summary.TaxCharged[n-1].Add(row.Tax); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// Aging splits amounts owed by how long they are past their due date.
+type Aging struct {
+	Current    Money `json:"current"` // Not due yet
+	Days1To30  Money `json:"days_1_30"`
+	Days31To60 Money `json:"days_31_60"`
+	Days61To90 Money `json:"days_61_90"`
+	Over90     Money `json:"over_90"`
+	Total      Money `json:"total"`
+}
+
+func newAging(currency string) Aging {
+	zero := Money{Currency: currency}
+	return Aging{zero, zero, zero, zero, zero, zero}
+}
+
+// add adds the amount to the bucket, numbered from 0 for current to 4 for over 90
+// days, and to the total.
+func (a *Aging) add(bucket int, amount Money) error {
+	buckets := []*Money{&a.Current, &a.Days1To30, &a.Days31To60, &a.Days61To90, &a.Over90}
+	if bucket < 0 || bucket >= len(buckets) {
+		return fmt.Errorf("unknown aging bucket %d", bucket)
+	}
+	var err error
+	if *buckets[bucket], err = buckets[bucket].Add(amount); err != nil {
+		return err
+	}
+	a.Total, err = a.Total.Add(amount)
+	return err
+}
+
+// CustomerAging is what a customer owes, by age.
+type CustomerAging struct {
+	CustomerID int64  `json:"customer_id"`
+	Name       string `json:"name"`
+	Invoices   int    `json:"invoices"` // Invoices with a balance left
+	Aging
+}
+
+// AgingReport holds the balances left to pay on a date, per customer, converted into
+// a single base currency with the exchange rates effective on that date.
+type AgingReport struct {
+	BaseCurrency string           `json:"base_currency"`
+	AsOf         time.Time        `json:"as_of"`
+	Customers    []*CustomerAging `json:"customers"`
+	Totals       Aging            `json:"totals"`
+}
+
+// ReceivablesAging works out what the customers in the scope owed at the end of the
+// asOf date, as recorded by then: on the invoices issued by then and not voided by
+// then, bucketed by the number of days they were past due. Payments received and
+// credit notes issued after the date are counted as still owed, and refunds made
+// after the date as still paid. It fails with a MissingExchangeRateError when a
+// balance is in a currency without a rate into the base currency on the date.
+func (m ReportModel) ReceivablesAging(baseCurrency string, asOf time.Time, scope CustomerScope) (*AgingReport, error) {
+	query := `
+	SELECT customers.id, customers.name, invoices.currency,
+		CASE
+			WHEN invoices.due_date >= $3::date THEN 0
+			WHEN $3::date - invoices.due_date <= 30 THEN 1
+			WHEN $3::date - invoices.due_date <= 60 THEN 2
+			WHEN $3::date - invoices.due_date <= 90 THEN 3
+			ELSE 4
+		END,
+		count(*),
+		sum(balance.amount * CASE WHEN invoices.currency = $1 THEN 1 ELSE rate.rate END)::text,
+		bool_or(invoices.currency <> $1 AND rate.rate IS NULL)
+	FROM invoices
+	INNER JOIN customers ON customers.id = invoices.customer_id
+	CROSS JOIN LATERAL (
+		SELECT invoices.total - invoices.amount_paid - invoices.amount_credited
+			+ coalesce((
+				SELECT sum(payments.amount)
+				FROM payments
+				WHERE payments.invoice_id = invoices.id AND payments.paid_on > $3::date
+			), 0)
+			+ coalesce((
+				SELECT sum(credit_notes.amount - credit_notes.refund)
+				FROM credit_notes
+				WHERE credit_notes.invoice_id = invoices.id AND credit_notes.issued_on > $3::date
+			), 0) AS amount
+	) balance
+	LEFT JOIN LATERAL (
+		SELECT exchange_rates.rate
+		FROM exchange_rates
+		WHERE exchange_rates.currency = invoices.currency
+		AND exchange_rates.base_currency = $1
+		AND exchange_rates.effective_on <= $3::date
+		ORDER BY exchange_rates.effective_on DESC
+		LIMIT 1
+	) rate ON true
+	WHERE ($2::bigint = 0 OR customers.account_manager_id = $2)
+	AND invoices.issue_date::date <= $3::date
+	AND invoices.issued_at::date <= $3::date
+	AND (invoices.voided_at IS NULL OR invoices.voided_at::date > $3::date)
+	AND balance.amount > 0
+	GROUP BY 1, 2, 3, 4
+	ORDER BY 2, 1, 3, 4
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, baseCurrency, scope.AccountManagerID, asOf)
+	if err != nil {
+		log.Println("Error getting receivables aging", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &AgingReport{
+		BaseCurrency: baseCurrency,
+		AsOf:         asOf,
+		Customers:    []*CustomerAging{},
+		Totals:       newAging(baseCurrency),
+	}
+	var current *CustomerAging
+
+	for rows.Next() {
+		var (
+			customerID       int64
+			name, currency   string
+			bucket, invoices int
+			converted        sql.NullString
+			missingRate      bool
+		)
+		err = rows.Scan(&customerID, &name, &currency, &bucket, &invoices, &converted, &missingRate)
+		if err != nil {
+			return nil, err
+		}
+		if missingRate {
+			return nil, &MissingExchangeRateError{Currency: currency, BaseCurrency: baseCurrency, Date: asOf}
+		}
+
+		value, ok := new(big.Rat).SetString(converted.String)
+		if !ok {
+			return nil, fmt.Errorf("invalid converted amount %q", converted.String)
+		}
+		balance, err := rescale(value, currency, baseCurrency)
+		if err != nil {
+			return nil, err
+		}
+
+		if current == nil || current.CustomerID != customerID {
+			current = &CustomerAging{CustomerID: customerID, Name: name, Aging: newAging(baseCurrency)}
+			report.Customers = append(report.Customers, current)
+		}
+		current.Invoices += invoices
+		if err = current.add(bucket, balance); err != nil {
+			return nil, err
+		}
+		if err = report.Totals.add(bucket, balance); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,75 @@
+package data
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestAgingAdd(t *testing.T) {
+	tests := []struct {
+		bucket int
+		field  func(a *Aging) Money
+		ok     bool
+	}{
+		{-1, nil, false},
+		{0, func(a *Aging) Money { return a.Current }, true},
+		{1, func(a *Aging) Money { return a.Days1To30 }, true},
+		{2, func(a *Aging) Money { return a.Days31To60 }, true},
+		{3, func(a *Aging) Money { return a.Days61To90 }, true},
+		{4, func(a *Aging) Money { return a.Over90 }, true},
+		{5, nil, false},
+	}
+
+	for _, tt := range tests {
+		aging := newAging("INR")
+		err := aging.add(tt.bucket, NewMoney(1500, "INR"))
+		if (err == nil) != tt.ok {
+			t.Errorf("add(%d) error = %v, want ok %t", tt.bucket, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			if aging != newAging("INR") {
+				t.Errorf("add(%d) failed but changed the aging to %+v", tt.bucket, aging)
+			}
+			continue
+		}
+		if got := tt.field(&aging); got.Amount != 1500 {
+			t.Errorf("add(%d) put %s in the bucket, want 15.00 INR", tt.bucket, got)
+		}
+		if aging.Total.Amount != 1500 {
+			t.Errorf("add(%d) total = %s, want 15.00 INR", tt.bucket, aging.Total)
+		}
+		sum := aging.Current.Amount + aging.Days1To30.Amount + aging.Days31To60.Amount + aging.Days61To90.Amount + aging.Over90.Amount
+		if sum != aging.Total.Amount {
+			t.Errorf("add(%d) buckets add up to %d, total is %d", tt.bucket, sum, aging.Total.Amount)
+		}
+	}
+}
+
+func TestAgingAddAccumulates(t *testing.T) {
+	aging := newAging("INR")
+	for _, bucket := range []int{0, 1, 1, 4, 4, 4} {
+		if err := aging.add(bucket, NewMoney(100, "INR")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := Aging{
+		Current:    NewMoney(100, "INR"),
+		Days1To30:  NewMoney(200, "INR"),
+		Days31To60: NewMoney(0, "INR"),
+		Days61To90: NewMoney(0, "INR"),
+		Over90:     NewMoney(300, "INR"),
+		Total:      NewMoney(600, "INR"),
+	}
+	if aging != want {
+		t.Errorf("aging = %+v, want %+v", aging, want)
+	}
+
+	if err := aging.add(0, NewMoney(100, "USD")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("adding another currency error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+	if err := aging.add(2, NewMoney(math.MaxInt64, "INR")); !errors.Is(err, ErrAmountOverflow) {
+		t.Errorf("adding past the largest total error = %v, want %v", err, ErrAmountOverflow)
+	}
+}
@@ -0,0 +1,117 @@
+package data
+
+import (
+	"company/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateRole = errors.New("duplicate role")
+	ErrUnknownRole   = errors.New("unknown role")
+)
+
+type Role struct {
+	ID          int64       `json:"id"`          // Unique integer ID for each role
+	Name        string      `json:"name"`        // Role name, referenced by users.role
+	Permissions Permissions `json:"permissions"` // Permission codes granted to the role
+}
+
+func ValidateRole(v *validator.Validator, role *Role) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// GetAll fetches every role together with the permissions granted to it.
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+	SELECT roles.id, roles.name,
+		COALESCE(array_agg(permissions.name ORDER BY permissions.name) FILTER (WHERE permissions.name IS NOT NULL), '{}')
+	FROM roles
+	LEFT JOIN role_permissions ON role_permissions.role_id = roles.id
+	LEFT JOIN permissions ON permissions.id = role_permissions.permission_id
+	GROUP BY roles.id
+	ORDER BY roles.id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		log.Println("Error getting roles", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+		err = rows.Scan(&role.ID, &role.Name, pq.Array((*[]string)(&role.Permissions)))
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// GetByName fetches a role and its permissions.
+func (m RoleModel) GetByName(name string) (*Role, error) {
+	query := `
+	SELECT roles.id, roles.name,
+		COALESCE(array_agg(permissions.name ORDER BY permissions.name) FILTER (WHERE permissions.name IS NOT NULL), '{}')
+	FROM roles
+	LEFT JOIN role_permissions ON role_permissions.role_id = roles.id
+	LEFT JOIN permissions ON permissions.id = role_permissions.permission_id
+	WHERE roles.name = $1
+	GROUP BY roles.id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var role Role
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(&role.ID, &role.Name, pq.Array((*[]string)(&role.Permissions)))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &role, nil
+}
+
+// Insert adds a new role, without any permissions.
+func (m RoleModel) Insert(role *Role) error {
+	query := `
+	INSERT INTO roles (name)
+	VALUES ($1)
+	RETURNING id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, role.Name).Scan(&role.ID)
+	if err != nil {
+		log.Println("Error creating role", err)
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRole
+		default:
+			return err
+		}
+	}
+	role.Permissions = Permissions{}
+	return nil
+}
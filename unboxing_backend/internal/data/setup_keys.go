@@ -0,0 +1,60 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidSetupKey = errors.New("invalid setup key")
+	ErrSetupCompleted  = errors.New("setup already completed")
+)
+
+// SetupKeyModel manages the one-time key which allows registering the first
+// administrator through the API. Only the SHA-256 hash of the key is stored.
+type SetupKeyModel struct {
+	DB *sql.DB
+}
+
+// New() replaces any previous setup key with a freshly generated one and returns its
+// plaintext, which is the only time the plaintext is available.
+func (m SetupKeyModel) New() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	plaintext := hex.EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `DELETE FROM setup_keys`)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO setup_keys (hash) VALUES ($1)`, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return plaintext, tx.Commit()
+}
+
+// DeleteAll() removes any outstanding setup key, once an administrator exists there
+// is nothing left to set up.
+func (m SetupKeyModel) DeleteAll() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM setup_keys`)
+	return err
+}
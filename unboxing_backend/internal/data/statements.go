@@ -0,0 +1,174 @@
+package data
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Statement entries are billed amounts and refunds, which add to what the customer
+// owes, or payments received from the customer and credit notes, which take off it.
+const (
+	StatementBilling    = "billing"
+	StatementPayment    = "payment"
+	StatementCreditNote = "credit_note"
+	StatementRefund     = "refund"
+)
+
+// StatementEntry is one line of a customer statement, with the balance owed once
+// it's accounted for.
+type StatementEntry struct {
+	Date        time.Time `json:"date"`
+	Type        string    `json:"type"`
+	BillingID   int64     `json:"billing_id"`
+	Number      string    `json:"number"` // Invoice number
+	Description string    `json:"description"`
+	Amount      Money     `json:"amount"`
+	Balance     Money     `json:"balance"`
+}
+
+// Debit reports whether the entry adds to what the customer owes.
+func (e *StatementEntry) Debit() bool {
+	return e.Type == StatementBilling || e.Type == StatementRefund
+}
+
+// StatementAccount is the part of a statement in one currency, as balances in
+// different currencies can't be added up.
+type StatementAccount struct {
+	Currency       string            `json:"currency"`
+	OpeningBalance Money             `json:"opening_balance"`
+	Entries        []*StatementEntry `json:"entries"`
+	ClosingBalance Money             `json:"closing_balance"`
+}
+
+// Statement is the account of a customer over a period: what they owed at its
+// start, what was billed and paid during the period, and what they owed at its end.
+type Statement struct {
+	CustomerID int64               `json:"customer_id"`
+	From       time.Time           `json:"from"`
+	To         time.Time           `json:"to"`
+	Accounts   []*StatementAccount `json:"accounts"`
+}
+
+// Statement builds the statement of the customer from the billing entries and the
+// payments dated from `from` up to, but excluding, `to`. Drafts and voided entries
+// were never owed and are left out. The customer must have been checked to be in
+// scope beforehand.
+func (m BillingModel) Statement(customerID int64, from, to time.Time) (*Statement, error) {
+	// Entries paid before payments were recorded one by one are settled by a single
+	// payment of the amount paid, dated with the entry. Refunds came off the amount
+	// paid, which is why they are added back to it.
+	query := `
+	SELECT billing.date::date, 'billing', billing.id, coalesce(billing.number, ''), billing.currency, billing.amount, ''
+	FROM billing
+	WHERE billing.customer_id = $1 AND billing.status NOT IN ('draft', 'void')
+	AND billing.date::date < $2
+	UNION ALL
+	SELECT payments.paid_on, 'payment', billing.id, coalesce(billing.number, ''), payments.currency, payments.amount,
+		trim(payments.method || ' ' || payments.reference)
+	FROM payments
+	INNER JOIN billing ON billing.id = payments.invoice_id
+	WHERE billing.customer_id = $1 AND payments.paid_on < $2
+	UNION ALL
+	SELECT credit_notes.issued_on, 'credit_note', billing.id, coalesce(billing.number, ''), credit_notes.currency, credit_notes.amount,
+		credit_notes.number
+	FROM credit_notes
+	INNER JOIN billing ON billing.id = credit_notes.invoice_id
+	WHERE billing.customer_id = $1 AND credit_notes.issued_on < $2
+	UNION ALL
+	SELECT credit_notes.issued_on, 'refund', billing.id, coalesce(billing.number, ''), credit_notes.currency, credit_notes.refund,
+		credit_notes.number
+	FROM credit_notes
+	INNER JOIN billing ON billing.id = credit_notes.invoice_id
+	WHERE billing.customer_id = $1 AND credit_notes.issued_on < $2 AND credit_notes.refund > 0
+	UNION ALL
+	SELECT billing.date::date, 'payment', billing.id, coalesce(billing.number, ''), billing.currency,
+		billing.amount_paid + refunded.amount - recorded.amount, ''
+	FROM billing
+	CROSS JOIN LATERAL (
+		SELECT coalesce(sum(payments.amount), 0) AS amount
+		FROM payments
+		WHERE payments.invoice_id = billing.id
+	) recorded
+	CROSS JOIN LATERAL (
+		SELECT coalesce(sum(credit_notes.refund), 0) AS amount
+		FROM credit_notes
+		WHERE credit_notes.invoice_id = billing.id
+	) refunded
+	WHERE billing.customer_id = $1 AND billing.amount_paid + refunded.amount > recorded.amount
+	AND billing.date::date < $2
+	ORDER BY 1, 2, 3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, customerID, to)
+	if err != nil {
+		log.Println("Error getting customer statement", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	statement := &Statement{CustomerID: customerID, From: from, To: to, Accounts: []*StatementAccount{}}
+	accounts := map[string]*StatementAccount{}
+
+	for rows.Next() {
+		var (
+			entry    StatementEntry
+			currency string
+			details  string
+		)
+		err = rows.Scan(&entry.Date, &entry.Type, &entry.BillingID, &entry.Number, &currency, &entry.Amount, &details)
+		if err != nil {
+			return nil, err
+		}
+		entry.Amount.Currency = currency
+
+		account, ok := accounts[currency]
+		if !ok {
+			zero := Money{Currency: currency}
+			account = &StatementAccount{Currency: currency, OpeningBalance: zero, Entries: []*StatementEntry{}, ClosingBalance: zero}
+			accounts[currency] = account
+			statement.Accounts = append(statement.Accounts, account)
+		}
+
+		amount := entry.Amount
+		if !entry.Debit() {
+			amount = amount.Neg()
+		}
+		if account.ClosingBalance, err = account.ClosingBalance.Add(amount); err != nil {
+			return nil, err
+		}
+		if entry.Date.Before(from) {
+			account.OpeningBalance = account.ClosingBalance
+			continue
+		}
+
+		entry.Balance = account.ClosingBalance
+		entry.Description = statementDescription(entry.Type, entry.Number, details)
+		account.Entries = append(account.Entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+// statementDescription describes an entry, the details of payments being their method
+// and reference, and those of credit notes and refunds the number of the credit note.
+func statementDescription(entryType, number, details string) string {
+	switch {
+	case entryType == StatementBilling:
+		return "Invoice " + number
+	case entryType == StatementCreditNote:
+		return "Credit note " + details + " on invoice " + number
+	case entryType == StatementRefund:
+		return "Refund of credit note " + details + " on invoice " + number
+	case details != "":
+		return "Payment (" + details + ") on invoice " + number
+	default:
+		return "Payment on invoice " + number
+	}
+}
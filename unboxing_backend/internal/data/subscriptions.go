@@ -0,0 +1,458 @@
+package data
+
+import (
+	"company/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SubscriptionIntervals lists how often subscriptions can bill their customer.
+func SubscriptionIntervals() []string {
+	return []string{"weekly", "monthly", "quarterly", "yearly"}
+}
+
+// subscriptionsLockKey is the Postgres advisory lock held while due subscriptions are
+// billed, so that only one API instance bills them at a time.
+const subscriptionsLockKey int64 = 0x5355425343524942
+
+// Subscription bills a customer the same amount at every interval, from StartsOn
+// until EndsOn if set. NextRunOn is the date of the next billing entry to generate.
+type Subscription struct {
+	ID          int64      `json:"id"`
+	CustomerID  int64      `json:"customer_id"`
+	Description string     `json:"description"`
+	Amount      Money      `json:"amount"` // Before tax
+	TaxCategory string     `json:"tax_category,omitempty"`
+	Interval    string     `json:"interval"`
+	StartsOn    time.Time  `json:"starts_on"`
+	NextRunOn   time.Time  `json:"next_run_on"`
+	Runs        int        `json:"runs"` // Billing entries generated so far
+	EndsOn      *time.Time `json:"ends_on"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Version     int32      `json:"version"`
+}
+
+// RunOn returns the date of the nth billing entry of the subscription, counting from
+// 0 for the one on the start date. Monthly, quarterly and yearly entries fall on the
+// day of the month of the start date, or on the last day of shorter months.
+func (s *Subscription) RunOn(n int) time.Time {
+	switch s.Interval {
+	case "weekly":
+		return s.StartsOn.AddDate(0, 0, 7*n)
+	case "quarterly":
+		return addMonths(s.StartsOn, 3*n)
+	case "yearly":
+		return addMonths(s.StartsOn, 12*n)
+	default:
+		return addMonths(s.StartsOn, n)
+	}
+}
+
+// addMonths adds the months to the date, keeping its day of the month unless the
+// month is shorter.
+func addMonths(date time.Time, months int) time.Time {
+	first := time.Date(date.Year(), date.Month()+time.Month(months), 1, 0, 0, 0, 0, date.Location())
+	day := min(date.Day(), first.AddDate(0, 1, -1).Day())
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, date.Location())
+}
+
+func ValidateSubscription(v *validator.Validator, subscription *Subscription) {
+	v.Check(subscription.CustomerID > 0, "customer_id", "must be provided")
+	v.Check(len(subscription.Description) <= 500, "description", "must not be more than 500 bytes long")
+	ValidateMoney(v, "amount", subscription.Amount)
+	if subscription.TaxCategory != "" {
+		ValidateTaxCategory(v, "tax_category", subscription.TaxCategory)
+	}
+	v.Check(validator.In(subscription.Interval, SubscriptionIntervals()...), "interval", "must be weekly, monthly, quarterly or yearly")
+	v.Check(!subscription.StartsOn.IsZero(), "starts_on", "must be provided")
+	if subscription.EndsOn != nil {
+		v.Check(!subscription.EndsOn.Before(subscription.StartsOn), "ends_on", "must not be before the start date")
+	}
+}
+
+type SubscriptionModel struct {
+	DB        *sql.DB
+	Numbering Numbering
+}
+
+// subscriptionColumns are the columns scanned by scanSubscription.
+const subscriptionColumns = `subscriptions.id, subscriptions.customer_id, subscriptions.description, subscriptions.amount,
+	subscriptions.currency, coalesce(subscriptions.tax_category, ''), subscriptions.billing_interval, subscriptions.starts_on,
+	subscriptions.next_run_on, subscriptions.runs, subscriptions.ends_on, subscriptions.created_at, subscriptions.version`
+
+// scanSubscription scans subscriptionColumns, preceded by the destinations in extra.
+func scanSubscription(row interface{ Scan(...interface{}) error }, subscription *Subscription, extra ...interface{}) error {
+	var endsOn sql.NullTime
+	dest := append(extra,
+		&subscription.ID,
+		&subscription.CustomerID,
+		&subscription.Description,
+		&subscription.Amount,
+		&subscription.Amount.Currency,
+		&subscription.TaxCategory,
+		&subscription.Interval,
+		&subscription.StartsOn,
+		&subscription.NextRunOn,
+		&subscription.Runs,
+		&endsOn,
+		&subscription.CreatedAt,
+		&subscription.Version,
+	)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+	if endsOn.Valid {
+		subscription.EndsOn = &endsOn.Time
+	}
+	return nil
+}
+
+// GetAll fetches a page of the subscriptions of the customers visible in the scope,
+// optionally only those of a single customer (customerID 0 means every customer).
+func (m SubscriptionModel) GetAll(customerID int64, scope CustomerScope, filters Filters) ([]*Subscription, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), %s
+	FROM subscriptions
+	INNER JOIN customers ON customers.id = subscriptions.customer_id
+	WHERE ($1::bigint = 0 OR customers.account_manager_id = $1)
+	AND ($2::bigint = 0 OR subscriptions.customer_id = $2)
+	ORDER BY subscriptions.%s %s, subscriptions.id ASC
+	LIMIT $3 OFFSET $4
+	`, subscriptionColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	args := []interface{}{scope.AccountManagerID, customerID, filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println("Error getting subscriptions", err)
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	subscriptions := []*Subscription{}
+
+	for rows.Next() {
+		var subscription Subscription
+
+		err = scanSubscription(rows, &subscription, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		subscriptions = append(subscriptions, &subscription)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return subscriptions, metadata, nil
+}
+
+// Get fetches a subscription. Subscriptions of customers outside of the scope are
+// reported as not found.
+func (m SubscriptionModel) Get(id int64, scope CustomerScope) (*Subscription, error) {
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM subscriptions
+	INNER JOIN customers ON customers.id = subscriptions.customer_id
+	WHERE subscriptions.id = $1 AND ($2::bigint = 0 OR customers.account_manager_id = $2)
+	`, subscriptionColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var subscription Subscription
+	err := scanSubscription(m.DB.QueryRowContext(ctx, query, id, scope.AccountManagerID), &subscription)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			log.Println("Getting subscription", err)
+			return nil, err
+		}
+	}
+	return &subscription, nil
+}
+
+// Insert adds a new subscription, whose first billing entry is generated on its start
+// date. It fails with an UnknownTaxCategoryError when the tax category has no rate on
+// that date.
+func (m SubscriptionModel) Insert(subscription *Subscription) error {
+	query := `
+	INSERT INTO subscriptions (customer_id, description, amount, currency, tax_category, billing_interval, starts_on, next_run_on, ends_on)
+	VALUES ($1, $2, $3, $4, nullif($5, ''), $6, $7, $7, $8)
+	RETURNING id, next_run_on, runs, created_at, version
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if subscription.TaxCategory != "" {
+		if err := checkTaxCategory(ctx, m.DB, subscription.TaxCategory, subscription.StartsOn); err != nil {
+			return err
+		}
+	}
+	args := []interface{}{
+		subscription.CustomerID,
+		subscription.Description,
+		subscription.Amount,
+		subscription.Amount.Currency,
+		subscription.TaxCategory,
+		subscription.Interval,
+		subscription.StartsOn,
+		subscription.EndsOn,
+	}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&subscription.ID,
+		&subscription.NextRunOn,
+		&subscription.Runs,
+		&subscription.CreatedAt,
+		&subscription.Version,
+	)
+	if err != nil {
+		log.Println("Creating subscription in the database", err)
+		return err
+	}
+	log.Printf("Subscription with ID: %d created successfully in the database\n", subscription.ID)
+	return nil
+}
+
+// Update saves the description, amount, tax category and end date of the subscription,
+// unless it was changed since it was read. They apply to the billing entries generated
+// from then on, so the tax category must have a rate on the next run date.
+func (m SubscriptionModel) Update(subscription *Subscription) error {
+	query := `
+	UPDATE subscriptions
+	SET description = $1, amount = $2, currency = $3, tax_category = nullif($4, ''), ends_on = $5, version = version + 1
+	WHERE id = $6 AND version = $7
+	RETURNING version
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if subscription.TaxCategory != "" {
+		if err := checkTaxCategory(ctx, m.DB, subscription.TaxCategory, subscription.NextRunOn); err != nil {
+			return err
+		}
+	}
+	args := []interface{}{
+		subscription.Description,
+		subscription.Amount,
+		subscription.Amount.Currency,
+		subscription.TaxCategory,
+		subscription.EndsOn,
+		subscription.ID,
+		subscription.Version,
+	}
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&subscription.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			log.Println("Updating subscription", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a subscription of a customer within the scope. The billing entries
+// it generated are kept.
+func (m SubscriptionModel) Delete(id int64, scope CustomerScope) error {
+	query := `
+	DELETE FROM subscriptions
+	USING customers
+	WHERE customers.id = subscriptions.customer_id
+	AND subscriptions.id = $1 AND ($2::bigint = 0 OR customers.account_manager_id = $2)
+	`
+
+	results, err := m.DB.Exec(query, id, scope.AccountManagerID)
+	if err != nil {
+		log.Println("Delete operation", err)
+		return err
+	}
+
+	rowsAffected, err := results.RowsAffected()
+	if err != nil {
+		log.Println("Error checking rows affected", err)
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// BillDue generates the billing entries of every subscription due on or before the
+// date, catching up on the runs missed while no instance was running, and returns how
+// many it generated. It returns straight away when another instance is already
+// billing subscriptions. A subscription which can't be billed, e.g. because of an
+// unknown tax category, is skipped until the next call and its error returned once
+// the others are billed.
+//
+// Each entry is generated in its own transaction, together with the run recorded
+// against the subscription and the move of its next run date, so that a crash never
+// bills a run twice nor skips it.
+func (m SubscriptionModel) BillDue(date time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	// Session advisory locks belong to a connection, hold on to one until done
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, subscriptionsLockKey).Scan(&locked)
+	if err != nil {
+		log.Println("Locking subscriptions", err)
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, subscriptionsLockKey)
+
+	query := `
+	SELECT id
+	FROM subscriptions
+	WHERE next_run_on <= $1 AND (ends_on IS NULL OR next_run_on <= ends_on)
+	AND id <> ALL($2::bigint[])
+	ORDER BY next_run_on, id
+	LIMIT 100
+	`
+
+	billed := 0
+	failed := []int64{}
+	errs := []error{}
+	for {
+		rows, err := m.DB.QueryContext(ctx, query, date, pq.Array(failed))
+		if err != nil {
+			log.Println("Getting due subscriptions", err)
+			return billed, errors.Join(append(errs, err)...)
+		}
+		ids := []int64{}
+		for rows.Next() {
+			var id int64
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return billed, errors.Join(append(errs, err)...)
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return billed, errors.Join(append(errs, err)...)
+		}
+		if len(ids) == 0 {
+			return billed, errors.Join(errs...)
+		}
+
+		for _, id := range ids {
+			ok, err := m.billNextRun(ctx, id, date)
+			if err != nil {
+				failed = append(failed, id)
+				errs = append(errs, fmt.Errorf("billing subscription %d: %w", id, err))
+				continue
+			}
+			if ok {
+				billed++
+			}
+		}
+	}
+}
+
+// billNextRun generates the billing entry of the next run of the subscription if it
+// is due on or before the date, reporting whether it did.
+func (m SubscriptionModel) billNextRun(ctx context.Context, id int64, date time.Time) (bool, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM subscriptions
+	WHERE id = $1 AND next_run_on <= $2 AND (ends_on IS NULL OR next_run_on <= ends_on)
+	FOR UPDATE
+	`, subscriptionColumns)
+
+	var subscription Subscription
+	err = scanSubscription(tx.QueryRowContext(ctx, query, id, date), &subscription)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Billed in the meantime, or deleted
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	description := subscription.Description
+	if description == "" {
+		description = fmt.Sprintf("Subscription, %s", subscription.Interval)
+	}
+	invoice := &Invoice{
+		Series:     m.Numbering.DefaultInvoiceSeries(),
+		CustomerID: subscription.CustomerID,
+		Currency:   subscription.Amount.Currency,
+		IssueDate:  subscription.NextRunOn,
+		DueDate:    subscription.NextRunOn.AddDate(0, 0, DefaultPaymentTerms),
+		Status:     InvoiceIssued,
+		Lines: []*InvoiceLine{{
+			Description: description,
+			Quantity:    "1",
+			UnitPrice:   subscription.Amount,
+			TaxCategory: subscription.TaxCategory,
+			TaxRate:     "0",
+		}},
+	}
+	invoices := InvoiceModel{DB: m.DB, Numbering: m.Numbering}
+	if err = invoices.insert(ctx, tx, invoice); err != nil {
+		return false, err
+	}
+
+	// The primary key of the runs makes sure a run is never billed twice
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO subscription_runs (subscription_id, run_on, invoice_id)
+	VALUES ($1, $2, $3)
+	`, subscription.ID, subscription.NextRunOn, invoice.ID)
+	if err != nil {
+		log.Println("Recording subscription run", err)
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE subscriptions
+	SET runs = runs + 1, next_run_on = $1, version = version + 1
+	WHERE id = $2
+	`, subscription.RunOn(subscription.Runs+1), subscription.ID)
+	if err != nil {
+		log.Println("Moving subscription to its next run", err)
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	log.Printf("Subscription with ID: %d billed on %s as invoice %s\n", subscription.ID, subscription.NextRunOn.Format(time.DateOnly), invoice.Number)
+	return true, nil
+}
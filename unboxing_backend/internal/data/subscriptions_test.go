@@ -0,0 +1,94 @@
+package data
+
+import (
+	"company/internal/validator"
+	"testing"
+	"time"
+)
+
+func mustDate(t *testing.T, s string) time.Time {
+	t.Helper()
+	d, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestAddMonths(t *testing.T) {
+	tests := []struct {
+		date   string
+		months int
+		want   string
+	}{
+		{"2026-01-15", 1, "2026-02-15"},
+		{"2026-01-31", 1, "2026-02-28"},
+		{"2028-01-31", 1, "2028-02-29"},
+		{"2026-01-31", 2, "2026-03-31"},
+		{"2026-03-31", 1, "2026-04-30"},
+		{"2026-11-30", 3, "2027-02-28"},
+		{"2026-12-31", 12, "2027-12-31"},
+		{"2028-02-29", 12, "2029-02-28"},
+		{"2026-05-31", 0, "2026-05-31"},
+		{"2026-03-31", -1, "2026-02-28"},
+	}
+
+	for _, tt := range tests {
+		got := addMonths(mustDate(t, tt.date), tt.months)
+		if got.Format(time.DateOnly) != tt.want {
+			t.Errorf("addMonths(%s, %d) = %s, want %s", tt.date, tt.months, got.Format(time.DateOnly), tt.want)
+		}
+	}
+}
+
+func TestSubscriptionRunOn(t *testing.T) {
+	tests := []struct {
+		interval string
+		startsOn string
+		want     []string // Dates of the first runs
+	}{
+		{"weekly", "2026-12-29", []string{"2026-12-29", "2027-01-05", "2027-01-12"}},
+		{"monthly", "2026-01-31", []string{"2026-01-31", "2026-02-28", "2026-03-31", "2026-04-30"}},
+		{"monthly", "2026-08-29", []string{"2026-08-29", "2026-09-29", "2026-10-29"}},
+		{"quarterly", "2026-11-30", []string{"2026-11-30", "2027-02-28", "2027-05-30", "2027-08-30"}},
+		{"yearly", "2028-02-29", []string{"2028-02-29", "2029-02-28", "2030-02-28", "2031-02-28", "2032-02-29"}},
+	}
+
+	for _, tt := range tests {
+		s := &Subscription{Interval: tt.interval, StartsOn: mustDate(t, tt.startsOn)}
+		for n, want := range tt.want {
+			if got := s.RunOn(n).Format(time.DateOnly); got != want {
+				t.Errorf("%s from %s: RunOn(%d) = %s, want %s", tt.interval, tt.startsOn, n, got, want)
+			}
+		}
+	}
+}
+
+func TestValidateSubscriptionEndsOn(t *testing.T) {
+	tests := []struct {
+		endsOn string
+		valid  bool
+	}{
+		{"", true},
+		{"2026-05-01", true},
+		{"2026-06-01", true},
+		{"2026-04-30", false},
+	}
+
+	for _, tt := range tests {
+		s := &Subscription{
+			CustomerID: 1,
+			Amount:     NewMoney(10000, "INR"),
+			Interval:   "monthly",
+			StartsOn:   mustDate(t, "2026-05-01"),
+		}
+		if tt.endsOn != "" {
+			endsOn := mustDate(t, tt.endsOn)
+			s.EndsOn = &endsOn
+		}
+		v := validator.New()
+		if ValidateSubscription(v, s); v.Valid() != tt.valid {
+			t.Errorf("ends on %q: valid = %t, want %t: %v", tt.endsOn, v.Valid(), tt.valid, v.Errors)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package data
+
+import (
+	"company/internal/validator"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+)
+
+// Customers are billed tax as usual, exempt from tax, or under reverse charge, where
+// the customer accounts for the tax itself: the tax is worked out and shown on the
+// invoice but not charged.
+const (
+	TaxStandard      = "standard"
+	TaxExempt        = "exempt"
+	TaxReverseCharge = "reverse_charge"
+)
+
+// TaxTreatments lists the ways customers can be taxed.
+func TaxTreatments() []string {
+	return []string{TaxStandard, TaxExempt, TaxReverseCharge}
+}
+
+// TaxCategoryRX matches the codes of tax categories, such as "GST18" or "VAT_REDUCED".
+var TaxCategoryRX = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,19}$`)
+
+// ErrUnknownTaxCategory is returned when an invoice line refers to a tax category
+// which has no rate on the issue date of the invoice.
+var ErrUnknownTaxCategory = errors.New("unknown tax category")
+
+// TaxRate is the rate of a tax category, effective from EffectiveOn until the next
+// rate of the category.
+type TaxRate struct {
+	Category    string    `json:"category"`
+	Name        string    `json:"name"`
+	Rate        string    `json:"rate"` // Percentage, e.g. "18"
+	EffectiveOn time.Time `json:"effective_on"`
+}
+
+// UnknownTaxCategoryError tells which tax category had no rate on which date.
+type UnknownTaxCategoryError struct {
+	Category string
+	Date     time.Time
+}
+
+func (e *UnknownTaxCategoryError) Error() string {
+	return fmt.Sprintf("no rate of tax category %s on %s", e.Category, e.Date.Format(time.DateOnly))
+}
+
+func (e *UnknownTaxCategoryError) Unwrap() error {
+	return ErrUnknownTaxCategory
+}
+
+// TaxAmount is the tax of an invoice at one rate of one category.
+type TaxAmount struct {
+	Category      string `json:"category,omitempty"`
+	Rate          string `json:"rate"`
+	Taxable       Money  `json:"taxable"`
+	Tax           Money  `json:"tax"`
+	ReverseCharge bool   `json:"reverse_charge,omitempty"` // Tax due by the customer, not charged
+}
+
+func ValidateTaxCategory(v *validator.Validator, key, category string) {
+	v.Check(validator.Matches(category, TaxCategoryRX), key, "must be 1 to 20 capital letters, digits or underscores, starting with a letter")
+}
+
+func ValidateTaxRate(v *validator.Validator, rate *TaxRate) {
+	ValidateTaxCategory(v, "category", rate.Category)
+	v.Check(rate.Name != "", "name", "must be provided")
+	v.Check(len(rate.Name) <= 100, "name", "must not be more than 100 bytes long")
+	validatePercentage(v, "rate", rate.Rate)
+	v.Check(!rate.EffectiveOn.IsZero(), "effective_on", "must be provided")
+}
+
+// ValidateCustomerTax checks the tax details of the customer.
+func ValidateCustomerTax(v *validator.Validator, customer *Customer) {
+	v.Check(validator.In(customer.TaxTreatment, TaxTreatments()...), "tax_treatment", "invalid tax treatment")
+	v.Check(len(customer.TaxID) <= 50, "tax_id", "must not be more than 50 bytes long")
+}
+
+type TaxRateModel struct {
+	DB *sql.DB
+}
+
+// Insert adds the tax rate, replacing the rate of the category effective on the
+// same date if any. Invoices keep the rates they were saved with.
+func (m TaxRateModel) Insert(rate *TaxRate) error {
+	query := `
+	INSERT INTO tax_rates (category, name, rate, effective_on)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (category, effective_on) DO UPDATE SET name = EXCLUDED.name, rate = EXCLUDED.rate
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, rate.Category, rate.Name, rate.Rate, rate.EffectiveOn)
+	if err != nil {
+		log.Println("Inserting tax rate", err)
+		return err
+	}
+	return nil
+}
+
+// GetAll fetches every rate of every tax category, the most recent first, optionally
+// only those of one category.
+func (m TaxRateModel) GetAll(category string) ([]*TaxRate, error) {
+	query := `
+	SELECT category, name, rate::text, effective_on
+	FROM tax_rates
+	WHERE ($1::text = '' OR category = $1)
+	ORDER BY category ASC, effective_on DESC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, category)
+	if err != nil {
+		log.Println("Error getting tax rates", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []*TaxRate{}
+	for rows.Next() {
+		var rate TaxRate
+
+		err = rows.Scan(&rate.Category, &rate.Name, &rate.Rate, &rate.EffectiveOn)
+		if err != nil {
+			return nil, err
+		}
+		rate.Rate = trimDecimal(rate.Rate)
+		rates = append(rates, &rate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// checkTaxCategory makes sure the tax category has a rate in force on the date, failing
+// with an UnknownTaxCategoryError otherwise, for what is billed from then on.
+func checkTaxCategory(ctx context.Context, db *sql.DB, category string, date time.Time) error {
+	query := `
+	SELECT EXISTS (
+		SELECT 1
+		FROM tax_rates
+		WHERE category = $1 AND effective_on <= $2::date
+	)
+	`
+	var exists bool
+	err := db.QueryRowContext(ctx, query, category, date).Scan(&exists)
+	if err != nil {
+		log.Println("Checking tax category", err)
+		return err
+	}
+	if !exists {
+		return &UnknownTaxCategoryError{Category: category, Date: date}
+	}
+	return nil
+}
+
+// applyTaxes gives the invoice the tax treatment of its customer and the lines with a
+// tax category the rate of the category in force on the issue date, as part of the
+// transaction saving the invoice, then works out its totals again. Lines of invoices
+// to exempt customers are taxed at 0%.
+func applyTaxes(ctx context.Context, tx *sql.Tx, invoice *Invoice) error {
+	err := tx.QueryRowContext(ctx, `SELECT tax_treatment FROM customers WHERE id = $1`, invoice.CustomerID).Scan(&invoice.TaxTreatment)
+	if err != nil {
+		log.Println("Getting tax treatment of customer", err)
+		return err
+	}
+
+	query := `
+	SELECT rate::text
+	FROM tax_rates
+	WHERE category = $1 AND effective_on <= $2::date
+	ORDER BY effective_on DESC
+	LIMIT 1
+	`
+	rates := map[string]string{}
+	for _, line := range invoice.Lines {
+		if line.TaxCategory == "" {
+			continue
+		}
+		rate, ok := rates[line.TaxCategory]
+		if !ok {
+			err = tx.QueryRowContext(ctx, query, line.TaxCategory, invoice.IssueDate).Scan(&rate)
+			if err != nil {
+				switch {
+				case errors.Is(err, sql.ErrNoRows):
+					return &UnknownTaxCategoryError{Category: line.TaxCategory, Date: invoice.IssueDate}
+				default:
+					log.Println("Getting tax rate", err)
+					return err
+				}
+			}
+			rate = trimDecimal(rate)
+			rates[line.TaxCategory] = rate
+		}
+		line.TaxRate = rate
+	}
+
+	if invoice.TaxTreatment == TaxExempt {
+		for _, line := range invoice.Lines {
+			line.TaxRate = "0"
+		}
+	}
+	return invoice.CalculateTotals()
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"company/internal/validator"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestInvoiceTaxBreakdown(t *testing.T) {
+	lines := func() []*InvoiceLine {
+		return []*InvoiceLine{
+			{Quantity: "1", UnitPrice: NewMoney(10000, "INR"), TaxCategory: "GST18", TaxRate: "18"},
+			{Quantity: "2", UnitPrice: NewMoney(2500, "INR"), TaxCategory: "GST18", TaxRate: "18.0000"},
+			{Quantity: "1", UnitPrice: NewMoney(4000, "INR"), TaxCategory: "GST5", TaxRate: "5"},
+			{Quantity: "1", UnitPrice: NewMoney(1000, "INR"), TaxRate: "18"},
+		}
+	}
+	tests := []struct {
+		name      string
+		treatment string
+		taxTotal  int64
+		taxes     []TaxAmount
+	}{
+		{
+			name:      "standard",
+			treatment: TaxStandard,
+			taxTotal:  3080,
+			taxes: []TaxAmount{
+				{Category: "GST18", Rate: "18", Taxable: NewMoney(15000, "INR"), Tax: NewMoney(2700, "INR")},
+				{Category: "GST5", Rate: "5", Taxable: NewMoney(4000, "INR"), Tax: NewMoney(200, "INR")},
+				{Category: "", Rate: "18", Taxable: NewMoney(1000, "INR"), Tax: NewMoney(180, "INR")},
+			},
+		},
+		{
+			name:      "reverse charge",
+			treatment: TaxReverseCharge,
+			taxTotal:  0,
+			taxes: []TaxAmount{
+				{Category: "GST18", Rate: "18", Taxable: NewMoney(15000, "INR"), Tax: NewMoney(2700, "INR"), ReverseCharge: true},
+				{Category: "GST5", Rate: "5", Taxable: NewMoney(4000, "INR"), Tax: NewMoney(200, "INR"), ReverseCharge: true},
+				{Category: "", Rate: "18", Taxable: NewMoney(1000, "INR"), Tax: NewMoney(180, "INR"), ReverseCharge: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice := &Invoice{Currency: "INR", TaxTreatment: tt.treatment, Lines: lines()}
+			if err := invoice.CalculateTotals(); err != nil {
+				t.Fatal(err)
+			}
+			if invoice.Subtotal.Amount != 20000 || invoice.TaxTotal.Amount != tt.taxTotal {
+				t.Errorf("subtotal, tax = %d, %d, want 20000, %d", invoice.Subtotal.Amount, invoice.TaxTotal.Amount, tt.taxTotal)
+			}
+			if len(invoice.Taxes) != len(tt.taxes) {
+				t.Fatalf("got %d tax breakdown entries, want %d", len(invoice.Taxes), len(tt.taxes))
+			}
+			for n, want := range tt.taxes {
+				if got := *invoice.Taxes[n]; got != want {
+					t.Errorf("entry %d = %+v, want %+v", n, got, want)
+				}
+			}
+			for n, line := range invoice.Lines {
+				if tt.treatment == TaxReverseCharge && !line.Tax.IsZero() {
+					t.Errorf("line %d charges %s under reverse charge", n, line.Tax)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTaxRate(t *testing.T) {
+	tests := []struct {
+		rate  string
+		valid bool
+	}{
+		{"0", true},
+		{"18", true},
+		{"12.5", true},
+		{"100", true},
+		{"18.125", true},
+		{"18.0000", true},
+		{"18.0005", false},
+		{"100.01", false},
+		{"-5", false},
+		{"1e1", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateTaxRate(v, &TaxRate{Category: "GST18", Name: "GST", Rate: tt.rate, EffectiveOn: time.Now()})
+		if v.Valid() != tt.valid {
+			t.Errorf("ValidateTaxRate(%q) valid = %t, want %t: %v", tt.rate, v.Valid(), tt.valid, v.Errors)
+		}
+	}
+}
+
+func TestUnknownTaxCategoryError(t *testing.T) {
+	var err error = &UnknownTaxCategoryError{Category: "GST18", Date: time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)}
+	err = fmt.Errorf("saving invoice: %w", err)
+	if !errors.Is(err, ErrUnknownTaxCategory) {
+		t.Errorf("errors.Is(%v, ErrUnknownTaxCategory) = false", err)
+	}
+	if want := "saving invoice: no rate of tax category GST18 on 2026-04-01"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
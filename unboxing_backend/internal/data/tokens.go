@@ -5,11 +5,30 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
 	"time"
 )
 
 const (
 	ScopeAuthentication = "authentication"
+	ScopeRefresh        = "refresh"
+	ScopePasswordReset  = "password-reset"
+	ScopeActivation     = "activation"
+)
+
+// ErrTokenReused is returned when a refresh token that was already rotated is
+// presented again, which means it has most likely been stolen.
+var (
+	ErrTokenReused = errors.New("refresh token reused")
+)
+
+// Every plaintext token starts with a format version prefix. When the way tokens are
+// generated or hashed changes, a new prefix is added and hashToken() keeps accepting
+// the old ones, so that sessions issued before the change stay valid.
+const (
+	tokenPrefixV1 = "v1_"
 )
 
 type Token struct {
@@ -18,12 +37,13 @@ type Token struct {
 	UserId    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	Family    []byte    `json:"-"` // Tokens issued by the same login share a family
 }
 
 //example for token for a client side view
 /*
 {
-"token": "X3ASTT2CDAN66BACKSCI4SU7SI",
+"token": "v1_X3ASTT2CDAN66BACKSCI4SU7SIQ7VOAXPGFJZ4Y3NIP5HMGPK6VQA",
 "expiry": "2021-01-18T13:00:25.648511827+01:00"
 }
 */
@@ -37,19 +57,36 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 		Expiry: time.Now().Add(ttl),
 		Scope:  scope,
 	}
-	// Initialize a zero-valued byte slice with a length of 16 bytes.
+	// Initialize a zero-valued byte slice with a length of 32 bytes.
 	randomBytes := make([]byte, 32)
 	_, err := rand.Read(randomBytes)
 	if err != nil {
 		return nil, err
 	}
-	// Set the bytes in the token struct to be the random bytes.
-	token.PlainText = string(randomBytes)
-	hash := sha256.Sum256([]byte(randomBytes))
-	token.Hash = hash[:]
+	// Encode the random bytes to a base32 string without padding, so that the token
+	// only contains characters which are safe to send in an Authorization header,
+	// and mark it with the current format version.
+	token.PlainText = tokenPrefixV1 + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	token.Hash, err = hashToken(token.PlainText)
+	if err != nil {
+		return nil, err
+	}
 	return token, nil
 }
 
+// hashToken() returns the hash under which a plaintext token is stored in the tokens
+// table. The version prefix of the token decides how it is hashed; tokens with an
+// unknown prefix can never match a stored token.
+func hashToken(tokenPlaintext string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(tokenPlaintext, tokenPrefixV1):
+		hash := sha256.Sum256([]byte(tokenPlaintext))
+		return hash[:], nil
+	default:
+		return nil, ErrRecordNotFound
+	}
+}
+
 // Define the TokenModel type.
 type TokenModel struct {
 	DB *sql.DB
@@ -66,12 +103,116 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewPair() starts a new session for the user: it creates an access token and a
+// refresh token which belong to a fresh token family.
+func (m TokenModel) NewPair(userID int64, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
+	family := make([]byte, 16)
+	_, err := rand.Read(family)
+	if err != nil {
+		return nil, nil, err
+	}
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+	access.Family = family
+	refresh.Family = family
+	if err = m.Insert(access); err != nil {
+		return nil, nil, err
+	}
+	if err = m.Insert(refresh); err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
+// Rotate() exchanges a refresh token for a new access token and a new refresh token in
+// the same family. The old refresh token is marked as rotated rather than deleted, so
+// that if it is ever presented again we can tell it was reused and revoke the whole
+// family. Both new tokens get a full TTL, which gives us a sliding session expiry.
+func (m TokenModel) Rotate(refreshPlaintext string, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
+	hash, err := hashToken(refreshPlaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT user_id, family, expiry, rotated_at
+		FROM tokens
+		WHERE hash = $1 AND scope = $2
+		FOR UPDATE`
+	var (
+		userID    int64
+		family    []byte
+		expiry    time.Time
+		rotatedAt sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx, query, hash, ScopeRefresh).Scan(&userID, &family, &expiry, &rotatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrRecordNotFound
+		}
+		return nil, nil, err
+	}
+	if rotatedAt.Valid {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, family)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrTokenReused
+	}
+	if !expiry.After(time.Now()) {
+		return nil, nil, ErrRecordNotFound
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET rotated_at = $1 WHERE hash = $2`, time.Now(), hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+	query = `
+		INSERT INTO tokens (hash, user_id, expiry, scope, family)
+		VALUES ($1, $2, $3, $4, $5)`
+	for _, token := range []*Token{access, refresh} {
+		token.Family = family
+		_, err = tx.ExecContext(ctx, query, token.Hash, token.UserId, token.Expiry, token.Scope, token.Family)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, nil
+}
+
 // Insert() adds the data for a specific token to the tokens table.
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-INSERT INTO tokens (hash, user_id, expiry, scope)
-VALUES ($1, $2, $3, $4)`
-	args := []interface{}{token.Hash, token.UserId, token.Expiry, token.Scope}
+INSERT INTO tokens (hash, user_id, expiry, scope, family)
+VALUES ($1, $2, $3, $4, $5)`
+	args := []interface{}{token.Hash, token.UserId, token.Expiry, token.Scope, token.Family}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -88,3 +229,50 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteOtherSessions() logs the user out of every session but the one of the given
+// access token, and removes any outstanding password reset token. It is used once the
+// user changed their password, so that whoever knew the old one loses access.
+func (m TokenModel) DeleteOtherSessions(userID int64, tokenPlaintext string) error {
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1 AND scope IN ($2, $3, $4) AND hash <> $5
+		AND (family IS NULL OR family <> COALESCE(
+			(SELECT family FROM tokens WHERE hash = $5 AND scope = $2), ''::bytea))`
+	hash, err := hashToken(tokenPlaintext)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err = m.DB.ExecContext(ctx, query, userID, ScopeAuthentication, ScopeRefresh, ScopePasswordReset, hash)
+	return err
+}
+
+// Delete() removes a single token, identified by its plaintext value, for the given
+// scope, together with every other token of its family. It is used to revoke the
+// tokens of the current session on logout.
+func (m TokenModel) Delete(scope, tokenPlaintext string) error {
+	query := `
+		DELETE FROM tokens
+		WHERE (hash = $1 AND scope = $2)
+		OR family = (SELECT family FROM tokens WHERE hash = $1 AND scope = $2)`
+	hash, err := hashToken(tokenPlaintext)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, hash, scope)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -1,13 +1,18 @@
 package data
 
 import (
+	"company/internal/validator"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -25,9 +30,11 @@ type User struct {
 	CreatedAt time.Time `json:"created_at"` // Timestamp created for user automatically when added to the database
 	Name      string    `json:"name"`       // User's name
 	Email     string    `json:"email"`      // User's email address
-	Role      string    `json:"role"`       // User's role (Administrator, HR, Sales, Accountant)
+	Role      string    `json:"role"`       // User's primary role (Administrator, HR, Sales, Accountant)
+	Roles     []string  `json:"roles"`      // Every role of the user, the primary role first
 	Password  password  `json:"-"`
-	Version   int32     `json:"-"` // Version number for optimistic locking
+	Activated bool      `json:"activated"` // Whether the user has accepted their invite and set a password
+	Version   int32     `json:"-"`         // Version number for optimistic locking
 }
 
 // The Set() method calculates the bcrypt hash of a plaintext password, and stores both
@@ -42,6 +49,17 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// The SetRandom() method sets a random password that nobody knows. It is used for
+// invited users, who choose their own password when they activate their account.
+func (p *password) SetRandom() error {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+	return p.Set(base64.RawURLEncoding.EncodeToString(randomBytes))
+}
+
 // The Matches() method checks whether the provided plaintext password matches the
 // hashed password stored in the struct, returning true if it matches and false
 // otherwise.
@@ -58,71 +76,124 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+}
+
+// ValidatePasswordPlaintext() checks a password before it is hashed. bcrypt only looks
+// at the first 72 bytes, so anything longer is rejected.
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+}
+
+func ValidateUser(v *validator.Validator, user *User) {
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	ValidateEmail(v, user.Email)
+	v.Check(user.Role != "", "role", "must be provided")
+}
+
+// userRolesColumn selects every role of a user as an array: the primary role from
+// users.role, followed by the additional roles assigned through user_roles.
+const userRolesColumn = `array_prepend(users.role, ARRAY(
+		SELECT roles.name
+		FROM user_roles
+		INNER JOIN roles ON roles.id = user_roles.role_id
+		WHERE user_roles.user_id = users.id AND roles.name <> users.role
+		ORDER BY roles.name))`
+
+// administratorExistsQuery checks for a user holding the Administrator role, either
+// as their primary role or as an additional one.
+const administratorExistsQuery = `
+	SELECT EXISTS (SELECT 1 FROM users WHERE role = 'Administrator')
+	OR EXISTS (
+		SELECT 1
+		FROM user_roles
+		INNER JOIN roles ON roles.id = user_roles.role_id
+		WHERE roles.name = 'Administrator')`
+
 type UserModel struct {
 	DB *sql.DB
 }
 
-// GetAll fetches all users from the database.
-func (m UserModel) GetAll() ([]*User, error) {
-	query := `
-	SELECT id, created_at, name, email, role, version
+// GetAll fetches a page of users from the database. The name filter matches part of
+// the name, and the roles filter matches users holding any of the roles; empty
+// filters match everybody.
+func (m UserModel) GetAll(name string, roles []string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, email, role, `+userRolesColumn+`, activated, version
 	FROM users
-	ORDER BY id
-	`
+	WHERE (name ILIKE '%%' || $1 || '%%' OR $1 = '')
+	AND (cardinality($2::text[]) = 0 OR `+userRolesColumn+` && $2::text[])
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4
+	`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	args := []interface{}{name, pq.Array(roles), filters.limit(), filters.offset()}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		log.Println("Error getting users", err)
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := 0
 	users := []*User{}
 
 	for rows.Next() {
 		var user User
 
 		err = rows.Scan(
+			&totalRecords,
 			&user.ID,
 			&user.CreatedAt,
 			&user.Name,
 			&user.Email,
 			&user.Role,
+			pq.Array(&user.Roles),
+			&user.Activated,
 			&user.Version,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 		users = append(users, &user)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
-	return users, nil
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return users, metadata, nil
 }
 
 // Insert adds a new user to the database.
 func (m UserModel) Insert(user *User) error {
 	query := `
-	INSERT INTO users (name, email, password_hash, role)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO users (name, email, password_hash, role, activated)
+	VALUES ($1, $2, $3, $4, $5)
 	RETURNING id, created_at, version
 	`
-	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Role}
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Role, user.Activated}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 	//using spread operator here
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	user.Roles = []string{user.Role}
 	if err != nil {
 		log.Println("Error creating user", err)
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
+		case err.Error() == `pq: insert or update on table "users" violates foreign key constraint "users_role_fkey"`:
+			return ErrUnknownRole
 		default:
 			return err
 		}
@@ -133,7 +204,7 @@ func (m UserModel) Insert(user *User) error {
 }
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-	SELECT id,created_at,name,email,password_hash,role,version
+	SELECT id,created_at,name,email,password_hash,role,` + userRolesColumn + `,activated,version
 	FROM users
 	WHERE email = $1
 	`
@@ -147,6 +218,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Role,
+		pq.Array(&user.Roles),
+		&user.Activated,
 		&user.Version,
 	)
 	if err != nil {
@@ -165,7 +238,7 @@ func (m UserModel) Get(id int64) (*User, error) {
 	defer cancel()
 
 	query := `
-	SELECT id, created_at, name, email, role, version 
+	SELECT id, created_at, name, email, password_hash, role, ` + userRolesColumn + `, activated, version
 	FROM users
 	WHERE id = $1
 	`
@@ -176,7 +249,10 @@ func (m UserModel) Get(id int64) (*User, error) {
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
+		&user.Password.hash,
 		&user.Role,
+		pq.Array(&user.Roles),
+		&user.Activated,
 		&user.Version,
 	)
 	if err != nil {
@@ -195,18 +271,20 @@ func (m UserModel) Get(id int64) (*User, error) {
 func (m UserModel) Update(user *User) error {
 	query := `
 	UPDATE users
-	SET name = $1, email = $2,password_hash = $3, role = $4, version = version + 1
-	WHERE id = $5 AND version = $6
+	SET name = $1, email = $2,password_hash = $3, role = $4, activated = $5, version = version + 1
+	WHERE id = $6 AND version = $7
 	RETURNING version
 	`
-	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Role, user.ID, user.Version}
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Role, user.Activated, user.ID, user.Version}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unqiue constraint "users_email_key"`:
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
+		case err.Error() == `pq: insert or update on table "users" violates foreign key constraint "users_role_fkey"`:
+			return ErrUnknownRole
 		case errors.Is(err, sql.ErrNoRows):
 			log.Println("Edit conflict (version)", err)
 			return ErrEditConflict
@@ -246,22 +324,91 @@ func (m UserModel) Delete(id int64) error {
 	return nil
 }
 
+// AdministratorExists reports whether at least one Administrator account exists.
+func (m UserModel) AdministratorExists() (bool, error) {
+	query := administratorExistsQuery
+	var exists bool
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query).Scan(&exists)
+	return exists, err
+}
+
+// InsertFirstAdministrator inserts the first Administrator, spending the one-time
+// setup key in the same transaction. Deleting the key locks its row, so when two
+// requests race with the same key only one of them can create an account; and if the
+// insert fails the key is kept for another attempt.
+func (m UserModel) InsertFirstAdministrator(user *User, setupKeyPlaintext string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	hash := sha256.Sum256([]byte(setupKeyPlaintext))
+	result, err := tx.ExecContext(ctx, `DELETE FROM setup_keys WHERE hash = $1`, hash[:])
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrInvalidSetupKey
+	}
+	var exists bool
+	err = tx.QueryRowContext(ctx, administratorExistsQuery).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrSetupCompleted
+	}
+
+	user.Role = "Administrator"
+	query := `
+	INSERT INTO users (name, email, password_hash, role, activated)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, version
+	`
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Role, user.Activated}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	user.Roles = []string{user.Role}
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 // retrievs the information assosiated with the token for
 // a a particular header
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	query := `
-SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.role, users.version
+SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.role, ` + userRolesColumn + `, users.activated, users.version
 FROM users
 INNER JOIN tokens
 ON users.id = tokens.user_id
 WHERE tokens.hash = $1
 AND tokens.scope = $2
 AND tokens.expiry > $3`
-	args := []interface{}{tokenPlaintext, tokenScope, time.Now()}
+	// Look tokens up by their hash, never by the plaintext the client sent us.
+	tokenHash, err := hashToken(tokenPlaintext)
+	if err != nil {
+		return nil, err
+	}
+	args := []interface{}{tokenHash, tokenScope, time.Now()}
 	var user User
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Password.hash, &user.Role, &user.Version)
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Name, &user.Email, &user.Password.hash, &user.Role, pq.Array(&user.Roles), &user.Activated, &user.Version)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrRecordNotFound
@@ -270,3 +417,37 @@ AND tokens.expiry > $3`
 	}
 	return &user, nil
 }
+
+// AddRole assigns an additional role to the user. Assigning a role the user already
+// has is not an error.
+func (m UserModel) AddRole(userID, roleID int64) error {
+	query := `
+	INSERT INTO user_roles (user_id, role_id)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, roleID)
+	return err
+}
+
+// RemoveRole unassigns an additional role from the user.
+func (m UserModel) RemoveRole(userID, roleID int64) error {
+	query := `
+	DELETE FROM user_roles
+	WHERE user_id = $1 AND role_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, userID, roleID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
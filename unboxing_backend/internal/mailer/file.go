@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileMailer writes every email to a file in a directory instead of sending it, which
+// is handy during development when there is no SMTP server around.
+type FileMailer struct {
+	dir    string
+	sender string
+}
+
+func NewFile(dir, sender string) FileMailer {
+	return FileMailer{dir: dir, sender: sender}
+}
+
+func (m FileMailer) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(m.dir, 0o755)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d_%s_%s.eml",
+		time.Now().UnixNano(), strings.TrimSuffix(templateFile, filepath.Ext(templateFile)), recipient)
+	body, err := msg.mime()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(m.dir, name), body, 0o600)
+}
+
+// MemoryMailer keeps every email in memory so that the messages can be inspected,
+// for example to pick the token out of a password reset email.
+type MemoryMailer struct {
+	sender   string
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemory(sender string) *MemoryMailer {
+	return &MemoryMailer{sender: sender}
+}
+
+func (m *MemoryMailer) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, *msg)
+	return nil
+}
+
+// Messages returns a copy of all the emails sent so far.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
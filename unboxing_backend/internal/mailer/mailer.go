@@ -0,0 +1,67 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	ttemplate "text/template"
+)
+
+// The email templates live next to the code and are compiled into the binary, so the
+// mailer keeps working no matter which directory the API is started from.
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Message is a rendered email, ready to be handed to a transport.
+type Message struct {
+	Sender    string
+	Recipient string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Mailer sends an email to the recipient, built from one of the templates in the
+// templates directory and the dynamic data for it.
+type Mailer interface {
+	Send(recipient, templateFile string, data interface{}) error
+}
+
+// render executes the "subject", "plainBody" and "htmlBody" templates of the given
+// template file and returns the resulting message.
+func render(sender, recipient, templateFile string, data interface{}) (*Message, error) {
+	textTmpl, err := ttemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+	subject := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+	plainBody := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The HTML body goes through html/template so that the data is escaped properly.
+	htmlTmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Sender:    sender,
+		Recipient: recipient,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
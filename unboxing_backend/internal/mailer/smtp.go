@@ -0,0 +1,92 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// SMTPMailer delivers emails through an SMTP server.
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	sender   string
+}
+
+func NewSMTP(host string, port int, username, password, sender string) SMTPMailer {
+	return SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		sender:   sender,
+	}
+}
+
+func (m SMTPMailer) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+	body, err := msg.mime()
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+	addr := m.host + ":" + strconv.Itoa(m.port)
+	// Try sending the email up to three times before giving up, the SMTP server may
+	// just be temporarily unavailable.
+	for i := 1; i <= 3; i++ {
+		err = smtp.SendMail(addr, auth, m.sender, []string{recipient}, body)
+		if err == nil {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return err
+}
+
+// mime encodes the message as a multipart/alternative email with a plain text and an
+// HTML part.
+func (msg *Message) mime() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writer := multipart.NewWriter(buf)
+
+	fmt.Fprintf(buf, "From: %s\r\n", msg.Sender)
+	fmt.Fprintf(buf, "To: %s\r\n", msg.Recipient)
+	fmt.Fprintf(buf, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=UTF-8", msg.PlainBody},
+		{"text/html; charset=UTF-8", msg.HTMLBody},
+	}
+	for _, p := range parts {
+		part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {p.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		_, err = part.Write([]byte(p.body))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
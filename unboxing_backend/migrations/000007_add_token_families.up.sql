@@ -0,0 +1,5 @@
+-- tokens issued by the same login share a family, so that a reused refresh token
+-- can revoke every token of the session it belongs to
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family bytea;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS rotated_at timestamp(0) with time zone;
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family);
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activated bool NOT NULL DEFAULT false;
+-- accounts created before activation existed are already in use
+UPDATE users SET activated = true;
@@ -0,0 +1,5 @@
+--one-time key used to register the first administrator, only the hash is stored
+CREATE TABLE IF NOT EXISTS setup_keys (
+    hash bytea PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
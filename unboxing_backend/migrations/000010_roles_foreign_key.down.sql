@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE name = 'manage_roles';
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_fkey;
+ALTER TABLE users ADD CONSTRAINT users_role_check
+    CHECK (role IN ('Sales', 'Accountant', 'HR', 'Administrator'));
@@ -0,0 +1,10 @@
+-- users.role used to be limited to a fixed list, point it at the roles table instead
+-- so that roles created through the API can be given to users
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users ADD CONSTRAINT users_role_fkey
+    FOREIGN KEY (role) REFERENCES roles(name) ON UPDATE CASCADE;
+
+INSERT INTO permissions (name) VALUES ('manage_roles') ON CONFLICT DO NOTHING;
+INSERT INTO role_permissions (role_id, permission_id) VALUES
+    ((SELECT id FROM roles WHERE name = 'Administrator'), (SELECT id FROM permissions WHERE name = 'manage_roles'))
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+-- one-off permissions for a single user, on top of the permissions of their roles;
+-- a deny overrides the same permission granted through a role
+CREATE TABLE IF NOT EXISTS user_permissions (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    permission_id BIGINT NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    effect TEXT NOT NULL CHECK (effect IN ('grant', 'deny')),
+    PRIMARY KEY (user_id, permission_id)
+);
@@ -0,0 +1,7 @@
+DELETE FROM role_permissions
+    WHERE role_id = (SELECT id FROM roles WHERE name = 'Administrator')
+    AND permission_id = (SELECT id FROM permissions WHERE name = 'manage_customers');
+DELETE FROM roles WHERE name = 'Sales Manager';
+DELETE FROM permissions WHERE name = 'view_all_customers';
+DROP INDEX IF EXISTS customers_account_manager_id_idx;
+ALTER TABLE customers DROP COLUMN IF EXISTS account_manager_id;
@@ -0,0 +1,18 @@
+-- customers belong to the Sales user managing the account, Sales users only see their
+-- own customers unless they hold view_all_customers
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS account_manager_id BIGINT
+    REFERENCES users(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS customers_account_manager_id_idx ON customers (account_manager_id);
+
+INSERT INTO permissions (name) VALUES ('view_all_customers') ON CONFLICT DO NOTHING;
+INSERT INTO roles (name) VALUES ('Sales Manager') ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id) VALUES
+    ((SELECT id FROM roles WHERE name = 'Administrator'), (SELECT id FROM permissions WHERE name = 'manage_customers')),
+    ((SELECT id FROM roles WHERE name = 'Administrator'), (SELECT id FROM permissions WHERE name = 'view_all_customers')),
+    ((SELECT id FROM roles WHERE name = 'Accountant'), (SELECT id FROM permissions WHERE name = 'view_all_customers')),
+    ((SELECT id FROM roles WHERE name = 'Sales Manager'), (SELECT id FROM permissions WHERE name = 'manage_customers')),
+    ((SELECT id FROM roles WHERE name = 'Sales Manager'), (SELECT id FROM permissions WHERE name = 'view_all_customers')),
+    ((SELECT id FROM roles WHERE name = 'Sales Manager'), (SELECT id FROM permissions WHERE name = 'manage_billing')),
+    ((SELECT id FROM roles WHERE name = 'Sales Manager'), (SELECT id FROM permissions WHERE name = 'view_billing'))
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS billing_date_id_idx;
+DROP INDEX IF EXISTS payroll_date_id_idx;
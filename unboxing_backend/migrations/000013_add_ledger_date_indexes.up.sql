@@ -0,0 +1,3 @@
+-- keyset pagination walks the ledgers in (date, id) order
+CREATE INDEX IF NOT EXISTS billing_date_id_idx ON billing (date, id);
+CREATE INDEX IF NOT EXISTS payroll_date_id_idx ON payroll (date, id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS customers_search_idx;
+ALTER TABLE customers DROP COLUMN IF EXISTS search;
@@ -0,0 +1,8 @@
+-- full-text search over the customer details, the name weighs the most
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(email, '')), 'B') ||
+    setweight(to_tsvector('simple', coalesce(phone, '')), 'C') ||
+    setweight(to_tsvector('simple', coalesce(address, '')), 'D')
+) STORED;
+CREATE INDEX IF NOT EXISTS customers_search_idx ON customers USING GIN (search);
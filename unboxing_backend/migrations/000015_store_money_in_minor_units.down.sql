@@ -0,0 +1,7 @@
+ALTER TABLE payroll DROP COLUMN IF EXISTS currency;
+ALTER TABLE payroll ALTER COLUMN amount TYPE NUMERIC(10, 2) USING amount / 100.0;
+COMMENT ON COLUMN payroll.amount IS NULL;
+
+ALTER TABLE billing DROP COLUMN IF EXISTS currency;
+ALTER TABLE billing ALTER COLUMN amount TYPE NUMERIC(10, 2) USING amount / 100.0;
+COMMENT ON COLUMN billing.amount IS NULL;
@@ -0,0 +1,12 @@
+-- amounts become whole minor units (paise, cents...) of the currency stored next to
+-- them; every amount recorded so far was in rupees
+ALTER TABLE billing ALTER COLUMN amount TYPE BIGINT USING round(amount * 100)::bigint;
+ALTER TABLE billing ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'INR';
+ALTER TABLE billing ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE payroll ALTER COLUMN amount TYPE BIGINT USING round(amount * 100)::bigint;
+ALTER TABLE payroll ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'INR';
+ALTER TABLE payroll ALTER COLUMN currency DROP DEFAULT;
+
+COMMENT ON COLUMN billing.amount IS 'Amount in minor units of the currency';
+COMMENT ON COLUMN payroll.amount IS 'Amount in minor units of the currency';
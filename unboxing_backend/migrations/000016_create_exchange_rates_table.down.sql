@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS billing_currency_date_idx;
+DROP TABLE IF EXISTS exchange_rates;
@@ -0,0 +1,9 @@
+-- rate is the value of one unit of currency in base_currency from effective_on on
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    currency CHAR(3) NOT NULL,
+    base_currency CHAR(3) NOT NULL,
+    rate NUMERIC(20, 10) NOT NULL CHECK (rate > 0),
+    effective_on DATE NOT NULL,
+    PRIMARY KEY (currency, base_currency, effective_on)
+);
+CREATE INDEX IF NOT EXISTS billing_currency_date_idx ON billing (currency, date);
@@ -0,0 +1,12 @@
+DROP VIEW IF EXISTS billing;
+DROP TABLE IF EXISTS invoice_lines;
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_number_key;
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_status_check;
+ALTER TABLE invoices DROP COLUMN IF EXISTS status;
+ALTER TABLE invoices DROP COLUMN IF EXISTS tax_total;
+ALTER TABLE invoices DROP COLUMN IF EXISTS subtotal;
+ALTER TABLE invoices DROP COLUMN IF EXISTS due_date;
+ALTER TABLE invoices DROP COLUMN IF EXISTS number;
+ALTER TABLE invoices RENAME COLUMN total TO amount;
+ALTER TABLE invoices RENAME COLUMN issue_date TO date;
+ALTER TABLE invoices RENAME TO billing;
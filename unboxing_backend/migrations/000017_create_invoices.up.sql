@@ -0,0 +1,42 @@
+-- billing entries become the invoices, the amount being the total computed from the
+-- invoice lines and the date the issue date
+ALTER TABLE billing RENAME TO invoices;
+ALTER TABLE invoices RENAME COLUMN date TO issue_date;
+ALTER TABLE invoices RENAME COLUMN amount TO total;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS number TEXT;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS due_date DATE;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS subtotal BIGINT;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS tax_total BIGINT NOT NULL DEFAULT 0;
+-- entries recorded so far were already sent to the customers
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'issued';
+ALTER TABLE invoices ALTER COLUMN status SET DEFAULT 'draft';
+ALTER TABLE invoices ADD CONSTRAINT invoices_status_check CHECK (status IN ('draft', 'issued', 'paid', 'void'));
+
+UPDATE invoices SET number = 'INV-' || lpad(id::text, 6, '0'), due_date = issue_date::date + 30, subtotal = total;
+ALTER TABLE invoices ALTER COLUMN number SET NOT NULL;
+ALTER TABLE invoices ALTER COLUMN due_date SET NOT NULL;
+ALTER TABLE invoices ALTER COLUMN subtotal SET NOT NULL;
+ALTER TABLE invoices ADD CONSTRAINT invoices_number_key UNIQUE (number);
+COMMENT ON COLUMN invoices.subtotal IS 'Amount in minor units of the currency';
+COMMENT ON COLUMN invoices.tax_total IS 'Amount in minor units of the currency';
+COMMENT ON COLUMN invoices.total IS 'Amount in minor units of the currency';
+
+CREATE TABLE IF NOT EXISTS invoice_lines (
+    id BIGSERIAL PRIMARY KEY,
+    invoice_id BIGINT NOT NULL REFERENCES invoices ON DELETE CASCADE,
+    position INT NOT NULL,
+    description TEXT NOT NULL,
+    quantity NUMERIC(12, 3) NOT NULL CHECK (quantity > 0),
+    unit_price BIGINT NOT NULL,
+    tax_rate NUMERIC(6, 3) NOT NULL DEFAULT 0 CHECK (tax_rate >= 0),
+    UNIQUE (invoice_id, position)
+);
+COMMENT ON COLUMN invoice_lines.unit_price IS 'Amount in minor units of the invoice currency';
+COMMENT ON COLUMN invoice_lines.tax_rate IS 'Percentage';
+INSERT INTO invoice_lines (invoice_id, position, description, quantity, unit_price)
+SELECT id, 1, 'Billing', 1, total FROM invoices;
+
+-- the billing entries live on as a summary of the invoices
+CREATE VIEW billing AS
+SELECT id, customer_id, total AS amount, currency, issue_date AS date, version
+FROM invoices;
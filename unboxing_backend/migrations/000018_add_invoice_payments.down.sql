@@ -0,0 +1,10 @@
+DROP VIEW IF EXISTS billing;
+CREATE VIEW billing AS
+SELECT id, customer_id, total AS amount, currency, issue_date AS date, version
+FROM invoices;
+DROP TABLE IF EXISTS payments;
+DROP FUNCTION IF EXISTS invoice_status(TEXT, DATE);
+ALTER TABLE invoices DROP COLUMN IF EXISTS amount_paid;
+UPDATE invoices SET status = 'issued' WHERE status = 'partially_paid';
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_status_check;
+ALTER TABLE invoices ADD CONSTRAINT invoices_status_check CHECK (status IN ('draft', 'issued', 'paid', 'void'));
@@ -0,0 +1,34 @@
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_status_check;
+ALTER TABLE invoices ADD CONSTRAINT invoices_status_check
+    CHECK (status IN ('draft', 'issued', 'partially_paid', 'paid', 'void'));
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS amount_paid BIGINT NOT NULL DEFAULT 0;
+COMMENT ON COLUMN invoices.amount_paid IS 'Amount in minor units of the currency';
+-- the billing entries recorded as paid before invoices existed
+UPDATE invoices SET amount_paid = total WHERE status = 'paid';
+
+-- overdue is not stored, invoices waiting for payment become overdue once their due
+-- date has passed
+CREATE OR REPLACE FUNCTION invoice_status(status TEXT, due_date DATE) RETURNS TEXT AS $$
+    SELECT CASE
+        WHEN status IN ('issued', 'partially_paid') AND due_date < current_date THEN 'overdue'
+        ELSE status
+    END
+$$ LANGUAGE SQL STABLE;
+
+CREATE TABLE IF NOT EXISTS payments (
+    id BIGSERIAL PRIMARY KEY,
+    invoice_id BIGINT NOT NULL REFERENCES invoices ON DELETE RESTRICT,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency CHAR(3) NOT NULL,
+    paid_on DATE NOT NULL,
+    method TEXT NOT NULL,
+    reference TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+COMMENT ON COLUMN payments.amount IS 'Amount in minor units of the currency';
+CREATE INDEX IF NOT EXISTS payments_invoice_id_idx ON payments (invoice_id);
+
+CREATE OR REPLACE VIEW billing AS
+SELECT id, customer_id, total AS amount, currency, issue_date AS date, version,
+    invoice_status(status, due_date) AS status, amount_paid
+FROM invoices;
@@ -0,0 +1,10 @@
+DROP VIEW IF EXISTS billing;
+CREATE VIEW billing AS
+SELECT id, customer_id, total AS amount, currency, issue_date AS date, version,
+    invoice_status(status, due_date) AS status, amount_paid
+FROM invoices;
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_number_check;
+UPDATE invoices SET number = 'INV-' || lpad(id::text, 6, '0') WHERE number IS NULL;
+ALTER TABLE invoices ALTER COLUMN number SET NOT NULL;
+ALTER TABLE invoices DROP COLUMN IF EXISTS series;
+DROP TABLE IF EXISTS number_series;
@@ -0,0 +1,19 @@
+-- last number allocated in each series and fiscal year, see data.Numbering
+CREATE TABLE IF NOT EXISTS number_series (
+    series TEXT NOT NULL,
+    fiscal_year INT NOT NULL,
+    last_number BIGINT NOT NULL CHECK (last_number > 0),
+    PRIMARY KEY (series, fiscal_year)
+);
+
+-- invoices only get a number when they are issued, so that deleting drafts leaves
+-- no gaps; the numbers given so far stay as they are
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS series TEXT NOT NULL DEFAULT 'INV';
+ALTER TABLE invoices ALTER COLUMN number DROP NOT NULL;
+UPDATE invoices SET number = NULL WHERE status = 'draft';
+ALTER TABLE invoices ADD CONSTRAINT invoices_number_check CHECK (status = 'draft' OR number IS NOT NULL);
+
+CREATE OR REPLACE VIEW billing AS
+SELECT id, customer_id, total AS amount, currency, issue_date AS date, version,
+    invoice_status(status, due_date) AS status, amount_paid, number
+FROM invoices;
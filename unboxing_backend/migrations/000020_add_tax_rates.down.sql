@@ -0,0 +1,11 @@
+DELETE FROM permissions WHERE name = 'manage_taxes';
+DROP VIEW IF EXISTS billing;
+CREATE VIEW billing AS
+SELECT id, customer_id, total AS amount, currency, issue_date AS date, version,
+    invoice_status(status, due_date) AS status, amount_paid, number
+FROM invoices;
+ALTER TABLE invoice_lines DROP COLUMN IF EXISTS tax_category;
+ALTER TABLE invoices DROP COLUMN IF EXISTS tax_treatment;
+ALTER TABLE customers DROP COLUMN IF EXISTS tax_id;
+ALTER TABLE customers DROP COLUMN IF EXISTS tax_treatment;
+DROP TABLE IF EXISTS tax_rates;
@@ -0,0 +1,40 @@
+-- rates of each tax category, effective from their date until the next rate of the
+-- category; invoice lines keep the rate in force on the issue date of their invoice
+CREATE TABLE IF NOT EXISTS tax_rates (
+    category TEXT NOT NULL,
+    name TEXT NOT NULL,
+    rate NUMERIC(6,3) NOT NULL CHECK (rate >= 0 AND rate <= 100),
+    effective_on DATE NOT NULL,
+    PRIMARY KEY (category, effective_on)
+);
+COMMENT ON COLUMN tax_rates.rate IS 'Percentage, e.g. 18';
+
+INSERT INTO tax_rates (category, name, rate, effective_on) VALUES
+    ('GST0', 'GST nil rated', 0, '2017-07-01'),
+    ('GST5', 'GST 5%', 5, '2017-07-01'),
+    ('GST12', 'GST 12%', 12, '2017-07-01'),
+    ('GST18', 'GST 18%', 18, '2017-07-01'),
+    ('GST28', 'GST 28%', 28, '2017-07-01')
+ON CONFLICT DO NOTHING;
+
+-- exempt customers are taxed at 0%, reverse charge customers account for the tax
+-- themselves so it is shown on their invoices but not charged
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS tax_treatment TEXT NOT NULL DEFAULT 'standard'
+    CHECK (tax_treatment IN ('standard', 'exempt', 'reverse_charge'));
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS tax_id TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS tax_treatment TEXT NOT NULL DEFAULT 'standard'
+    CHECK (tax_treatment IN ('standard', 'exempt', 'reverse_charge'));
+ALTER TABLE invoice_lines ADD COLUMN IF NOT EXISTS tax_category TEXT;
+
+CREATE OR REPLACE VIEW billing AS
+SELECT id, customer_id, total AS amount, currency, issue_date AS date, version,
+    invoice_status(status, due_date) AS status, amount_paid, number,
+    subtotal, tax_total, tax_treatment
+FROM invoices;
+
+INSERT INTO permissions (name) VALUES ('manage_taxes') ON CONFLICT DO NOTHING;
+INSERT INTO role_permissions (role_id, permission_id) VALUES
+    ((SELECT id FROM roles WHERE name = 'Administrator'), (SELECT id FROM permissions WHERE name = 'manage_taxes')),
+    ((SELECT id FROM roles WHERE name = 'Accountant'), (SELECT id FROM permissions WHERE name = 'manage_taxes'))
+ON CONFLICT DO NOTHING;
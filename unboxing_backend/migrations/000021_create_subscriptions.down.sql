@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS subscription_runs;
+DROP TABLE IF EXISTS subscriptions;
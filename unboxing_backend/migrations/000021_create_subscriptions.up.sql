@@ -0,0 +1,28 @@
+-- subscriptions bill their customer the same amount at every interval, the next
+-- billing entry being generated on next_run_on by the scheduler of the API
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id BIGSERIAL PRIMARY KEY,
+    customer_id BIGINT NOT NULL REFERENCES customers ON DELETE CASCADE,
+    description TEXT NOT NULL DEFAULT '',
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency CHAR(3) NOT NULL,
+    tax_category TEXT,
+    billing_interval TEXT NOT NULL CHECK (billing_interval IN ('weekly', 'monthly', 'quarterly', 'yearly')),
+    starts_on DATE NOT NULL,
+    next_run_on DATE NOT NULL,
+    runs INT NOT NULL DEFAULT 0,
+    ends_on DATE CHECK (ends_on >= starts_on),
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    version INT NOT NULL DEFAULT 1
+);
+COMMENT ON COLUMN subscriptions.amount IS 'Amount in minor units of the currency, before tax';
+CREATE INDEX IF NOT EXISTS subscriptions_customer_id_idx ON subscriptions (customer_id);
+CREATE INDEX IF NOT EXISTS subscriptions_next_run_on_idx ON subscriptions (next_run_on);
+
+-- the invoice generated for each run, a run being billed once only
+CREATE TABLE IF NOT EXISTS subscription_runs (
+    subscription_id BIGINT NOT NULL REFERENCES subscriptions ON DELETE CASCADE,
+    run_on DATE NOT NULL,
+    invoice_id BIGINT NOT NULL REFERENCES invoices ON DELETE CASCADE,
+    PRIMARY KEY (subscription_id, run_on)
+);
@@ -0,0 +1,10 @@
+DROP VIEW IF EXISTS billing;
+CREATE VIEW billing AS
+SELECT id, customer_id, total AS amount, currency, issue_date AS date, version,
+    invoice_status(status, due_date) AS status, amount_paid, number,
+    subtotal, tax_total, tax_treatment
+FROM invoices;
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_balance_check;
+ALTER TABLE invoices DROP COLUMN IF EXISTS amount_credited;
+DROP TABLE IF EXISTS credit_note_taxes;
+DROP TABLE IF EXISTS credit_notes;
@@ -0,0 +1,42 @@
+-- credit notes cancel all or part of an issued invoice instead of deleting it; the
+-- part of a credit note more than what was left to pay is refunded to the customer,
+-- and comes off the amount paid
+CREATE TABLE IF NOT EXISTS credit_notes (
+    id BIGSERIAL PRIMARY KEY,
+    invoice_id BIGINT NOT NULL REFERENCES invoices ON DELETE RESTRICT,
+    series TEXT NOT NULL DEFAULT 'CN',
+    number TEXT NOT NULL UNIQUE,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    refund BIGINT NOT NULL DEFAULT 0 CHECK (refund >= 0 AND refund <= amount),
+    currency CHAR(3) NOT NULL,
+    issued_on DATE NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+COMMENT ON COLUMN credit_notes.amount IS 'Amount in minor units of the currency, tax included';
+COMMENT ON COLUMN credit_notes.refund IS 'Amount in minor units of the currency';
+CREATE INDEX IF NOT EXISTS credit_notes_invoice_id_idx ON credit_notes (invoice_id);
+
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS amount_credited BIGINT NOT NULL DEFAULT 0;
+COMMENT ON COLUMN invoices.amount_credited IS 'Amount in minor units of the currency';
+ALTER TABLE invoices ADD CONSTRAINT invoices_balance_check CHECK (amount_paid + amount_credited <= total);
+
+CREATE OR REPLACE VIEW billing AS
+SELECT id, customer_id, total AS amount, currency, issue_date AS date, version,
+    invoice_status(status, due_date) AS status, amount_paid, number,
+    subtotal, tax_total, tax_treatment, amount_credited
+FROM invoices;
+
+-- what credit notes take off the tax breakdown of their invoice, in proportion to it,
+-- for the tax summary to report the tax net of credit notes
+CREATE TABLE IF NOT EXISTS credit_note_taxes (
+    credit_note_id BIGINT NOT NULL REFERENCES credit_notes ON DELETE CASCADE,
+    category TEXT NOT NULL DEFAULT '',
+    rate NUMERIC(6, 3) NOT NULL,
+    taxable BIGINT NOT NULL,
+    tax BIGINT NOT NULL,
+    reverse_charge BOOLEAN NOT NULL DEFAULT false,
+    PRIMARY KEY (credit_note_id, category, rate)
+);
+COMMENT ON COLUMN credit_note_taxes.taxable IS 'Amount in minor units of the currency';
+COMMENT ON COLUMN credit_note_taxes.tax IS 'Amount in minor units of the currency';
@@ -0,0 +1,14 @@
+-- the simple parser keeps emails and phone numbers whole, so their parts are added as
+-- terms of their own: the local part and domain of the email, the words within them,
+-- and the digits of the phone number
+ALTER TABLE customers DROP COLUMN IF EXISTS search;
+ALTER TABLE customers ADD COLUMN search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(email, '')), 'B') ||
+    setweight(to_tsvector('simple', replace(coalesce(email, ''), '@', ' ')), 'B') ||
+    setweight(to_tsvector('simple', regexp_replace(coalesce(email, ''), '[@.+_-]+', ' ', 'g')), 'B') ||
+    setweight(to_tsvector('simple', coalesce(phone, '')), 'C') ||
+    setweight(to_tsvector('simple', regexp_replace(coalesce(phone, ''), '[^0-9]+', '', 'g')), 'C') ||
+    setweight(to_tsvector('simple', coalesce(address, '')), 'D')
+) STORED;
+CREATE INDEX IF NOT EXISTS customers_search_idx ON customers USING GIN (search);
@@ -0,0 +1 @@
+-- the account managers given by the backfill can't be told apart from those given since
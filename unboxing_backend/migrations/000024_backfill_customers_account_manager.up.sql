@@ -0,0 +1,16 @@
+-- customers created before account managers existed have none, which hides them from
+-- every Sales user; when a single activated Sales user exists they are all theirs,
+-- otherwise they are handed out with `api assign-customers`
+UPDATE customers SET account_manager_id = sales.id
+FROM (
+    SELECT min(users.id) AS id
+    FROM users
+    WHERE users.activated AND (users.role = 'Sales' OR EXISTS (
+        SELECT 1
+        FROM user_roles
+        INNER JOIN roles ON roles.id = user_roles.role_id
+        WHERE user_roles.user_id = users.id AND roles.name = 'Sales'
+    ))
+    HAVING count(*) = 1
+) sales
+WHERE customers.account_manager_id IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE invoices DROP COLUMN IF EXISTS voided_at;
+ALTER TABLE invoices DROP COLUMN IF EXISTS issued_at;
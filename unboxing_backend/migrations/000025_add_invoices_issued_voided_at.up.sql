@@ -0,0 +1,7 @@
+-- when invoices were issued and voided, for reports as of a past date to see the
+-- invoices as they stood then; invoices issued so far count as issued on their issue
+-- date, and those voided so far as voided from the start since when is unknown
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS issued_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS voided_at TIMESTAMP(0) WITH TIME ZONE;
+UPDATE invoices SET issued_at = issue_date WHERE status <> 'draft' AND number IS NOT NULL;
+UPDATE invoices SET voided_at = issue_date WHERE status = 'void';
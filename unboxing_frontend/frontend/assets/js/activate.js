@@ -0,0 +1,50 @@
+document.addEventListener("DOMContentLoaded", function () {
+  const activateForm = document.getElementById("activateForm");
+  const messageElement = document.getElementById("message");
+  const token = new URLSearchParams(window.location.search).get("token");
+
+  if (!token) {
+    showMessage("This activation link is invalid.");
+    activateForm.style.display = "none";
+    return;
+  }
+
+  activateForm.addEventListener("submit", async function (event) {
+    event.preventDefault();
+
+    const formData = new FormData(activateForm);
+    if (formData.get("password") !== formData.get("confirm-password")) {
+      showMessage("The passwords do not match.");
+      return;
+    }
+
+    try {
+      const response = await fetch("http://localhost:4000/v1/users/activated", {
+        method: "PUT",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify({
+          token: token,
+          password: formData.get("password"),
+        }),
+        mode: "cors",
+      });
+
+      if (response.ok) {
+        window.location.href = "login.html";
+      } else {
+        const errorText = await response.text();
+        showMessage("Activation failed: " + errorText);
+      }
+    } catch (error) {
+      showMessage("An error occurred. Please try again.");
+      console.error("Error:", error);
+    }
+  });
+
+  function showMessage(message) {
+    messageElement.textContent = message;
+    messageElement.style.display = "block";
+  }
+});
@@ -10,7 +10,7 @@ document.addEventListener("DOMContentLoaded", function () {
     const formData = new FormData(addBillingForm);
     const data = {
       customer_id: parseInt(formData.get("customer_id")),
-      amount: parseFloat(formData.get("amount")),
+      amount: { amount: formData.get("amount"), currency: "INR" },
       date: formData.get("date"),
     };
 
@@ -11,7 +11,7 @@ document.addEventListener("DOMContentLoaded", function () {
     const formData = new FormData(addPayrollForm);
     const data = {
       employee_id: parseInt(formData.get("employee_id")),
-      amount: parseFloat(formData.get("amount")),
+      amount: { amount: formData.get("amount"), currency: "INR" },
       date: formData.get("date"),
     };
 
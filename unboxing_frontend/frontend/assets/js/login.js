@@ -42,7 +42,7 @@ document.addEventListener("DOMContentLoaded", function () {
         console.log("Authentication successful:", jsonResponse);
 
         // Store the authentication token in localStorage or sessionStorage
-        localStorage.setItem("authToken", jsonResponse.authentication_token);
+        localStorage.setItem("authToken", jsonResponse.authentication_token.token);
 
         // Redirect to the dashboard or another page
         window.location.href = "dashboard.html";
@@ -43,7 +43,6 @@ document.addEventListener("DOMContentLoaded", function () {
     const data = {
       name: formData.get("name"),
       email: formData.get("email"),
-      password: formData.get("password"),
       "secret-key": formData.get("secret-key"),
     };
 
@@ -62,8 +61,10 @@ document.addEventListener("DOMContentLoaded", function () {
       console.log("Response:", response); // Debugging: Log the response object
 
       if (response.ok) {
-        console.log("Registration successful, redirecting to login page"); // Debugging: Log success
-        window.location.href = "login.html"; // Redirect to login page
+        console.log("Registration successful, waiting for activation"); // Debugging: Log success
+        showMessage(
+          "Registration successful! Check your email for the link to set your password."
+        );
       } else {
         const errorText = await response.text();
         console.error("Registration failed:", errorText); // Debugging: Log error text
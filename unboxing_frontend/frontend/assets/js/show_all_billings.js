@@ -23,7 +23,7 @@ document.addEventListener("DOMContentLoaded", function () {
 
       const data = await response.json();
       console.log(data);
-      renderBillings(data);
+      renderBillings(data.billings);
     } catch (error) {
       billingMessage.textContent = "An error occurred while fetching billings.";
       billingMessage.style.display = "block";
@@ -38,7 +38,7 @@ document.addEventListener("DOMContentLoaded", function () {
       row.innerHTML = `
                 <td>${billing.id}</td>
                 <td>${billing.customer_id}</td>
-                <td>${billing.amount.toFixed(2)}</td>
+                <td>${billing.amount.amount} ${billing.amount.currency}</td>
                 <td>${new Date(billing.date).toLocaleDateString()}</td>
             `;
       billingsTableBody.appendChild(row);
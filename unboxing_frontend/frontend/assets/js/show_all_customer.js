@@ -16,8 +16,8 @@ document.addEventListener("DOMContentLoaded", function () {
         return;
       }
 
-      const customers = await response.json();
-      renderCustomerList(customers);
+      const data = await response.json();
+      renderCustomerList(data.customers);
     } catch (error) {
       customerList.innerHTML = "<p>An error occurred. Please try again.</p>";
       console.error("Error fetching customers:", error);
@@ -19,7 +19,7 @@ document.addEventListener("DOMContentLoaded", function () {
       console.log("Response:", response);
       const data = await response.json();
       console.log(data);
-      renderUserList(data);
+      renderUserList(data.users);
     } catch (error) {
       userList.innerHTML = "Error loading users";
       console.error("Error fetching users:", error);
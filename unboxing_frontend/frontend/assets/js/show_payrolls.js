@@ -18,7 +18,7 @@ document.addEventListener("DOMContentLoaded", function () {
       }
 
       const data = await response.json();
-      renderPayrollList(data);
+      renderPayrollList(data.payrolls);
     } catch (error) {
       payrollList.innerHTML = "Error loading payrolls";
       console.error("Error fetching payrolls:", error);
@@ -53,7 +53,7 @@ document.addEventListener("DOMContentLoaded", function () {
       const row = document.createElement("tr");
       row.innerHTML = `
                 <td>${payroll.employee_id}</td>
-                <td>${payroll.amount}</td>
+                <td>${payroll.amount.amount} ${payroll.amount.currency}</td>
                 <td>${new Date(payroll.date).toLocaleDateString()}</td>
             `;
       tbody.appendChild(row);